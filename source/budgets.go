@@ -4,16 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"database_sync/database"
-	"database_sync/utils"
 	"fmt"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoDBDelivery struct {
@@ -49,27 +47,32 @@ type MongoDBAddress struct {
 }
 
 type MongoDBBudgets struct {
-	ID                 bson.ObjectID    `json:"id,omitempty" bson:"_id,omitempty"`
-	OldID              uint64           `json:"old_id" bson:"old_id"`
-	CreatedBy          bson.ObjectID    `json:"created_by" bson:"created_by"`
-	Seller             bson.ObjectID    `json:"seller" bson:"seller"`
-	RelatedLead        bson.ObjectID    `json:"related_lead" bson:"related_lead"`
-	RelatedClient      bson.ObjectID    `json:"related_client" bson:"related_client"`
-	OldProductsList    string           `json:"old_products_list" bson:"old_products_list"`
-	Address            MongoDBAddress   `json:"address" bson:"address"`
-	Delivery           MongoDBDelivery  `json:"delivery" bson:"delivery"`
-	EarlyMode          MongoDBEarlyMode `json:"early_mode" bson:"early_mode"`
-	Discount           MongoDBDiscount  `json:"discount" bson:"discount"`
-	OldGifts           string           `json:"old_gifts" bson:"old_gifts"`
-	ProductionDeadline uint             `json:"production_deadline" bson:"production_deadline"`
-	Approved           bool             `json:"approved" bson:"approved"`
-	PaymentMethod      string           `json:"payment_method" bson:"payment_method"`
-	Billing            MongoDBBilling   `json:"billing" bson:"billing"`
-	Trello_uri         string           `json:"trello_uri" bson:"trello_uri"`
-	Notes              string           `json:"notes" bson:"notes"`
-	DeliveryForecast   time.Time        `json:"delivery_forecast" bson:"delivery_forecast"`
-	CreatedAt          time.Time        `json:"created_at" bson:"created_at,omitempty"`
-	UpdatedAt          time.Time        `json:"updated_at" bson:"updated_at,omitempty"`
+	ID                  bson.ObjectID        `json:"id,omitempty" bson:"_id,omitempty"`
+	OldID               uint64               `json:"old_id" bson:"old_id"`
+	CreatedBy           bson.ObjectID        `json:"created_by" bson:"created_by"`
+	Seller              bson.ObjectID        `json:"seller" bson:"seller"`
+	RelatedLead         bson.ObjectID        `json:"related_lead" bson:"related_lead"`
+	RelatedClient       bson.ObjectID        `json:"related_client" bson:"related_client"`
+	OldProductsList     string               `json:"old_products_list" bson:"old_products_list"`
+	Products            []MongoDBProductLine `json:"products" bson:"products"`
+	ProductsParseErrors []string             `json:"products_parse_errors,omitempty" bson:"products_parse_errors,omitempty"`
+	Address             MongoDBAddress       `json:"address" bson:"address"`
+	Delivery            MongoDBDelivery      `json:"delivery" bson:"delivery"`
+	EarlyMode           MongoDBEarlyMode     `json:"early_mode" bson:"early_mode"`
+	Discount            MongoDBDiscount      `json:"discount" bson:"discount"`
+	OldGifts            string               `json:"old_gifts" bson:"old_gifts"`
+	ProductionDeadline  uint                 `json:"production_deadline" bson:"production_deadline"`
+	Approved            bool                 `json:"approved" bson:"approved"`
+	PaymentMethod       string               `json:"payment_method" bson:"payment_method"`
+	Billing             MongoDBBilling       `json:"billing" bson:"billing"`
+	Trello_uri          string               `json:"trello_uri" bson:"trello_uri"`
+	Notes               string               `json:"notes" bson:"notes"`
+	DeliveryForecast    time.Time            `json:"delivery_forecast" bson:"delivery_forecast"`
+	CreatedAt           time.Time            `json:"created_at" bson:"created_at,omitempty"`
+	UpdatedAt           time.Time            `json:"updated_at" bson:"updated_at,omitempty"`
+	SyncHash            string               `json:"sync_hash,omitempty" bson:"sync_hash,omitempty"`
+	DeletedAt           *time.Time           `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBySync       bool                 `json:"deleted_by_sync,omitempty" bson:"deleted_by_sync,omitempty"`
 }
 
 type MySQLBudgets struct {
@@ -116,44 +119,29 @@ type MySQLBudgetsStatus struct {
 	ValorFaturamento  sql.NullFloat64 `db:"valor_faturamento"`
 	ValorFaturamento2 sql.NullFloat64 `db:"valor_faturamento_2"`
 	ValorFaturamento3 sql.NullFloat64 `db:"valor_faturamento_3"`
+	UpdatedAt         sql.NullTime    `db:"updated_at"`
 }
 
-func SyncBudgets() error {
-	mysqlURI := os.Getenv("MYSQL_URI")
-	if !strings.Contains(mysqlURI, "parseTime=true") {
-		if strings.Contains(mysqlURI, "?") {
-			mysqlURI += "&parseTime=true"
-		} else {
-			mysqlURI += "?parseTime=true"
-		}
-	}
+func SyncBudgets(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	mysqlDB := deps.Connections.MySQLParseTime()
 
-	mysqlDB, err := sql.Open("mysql", mysqlURI)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
-	}
-	defer mysqlDB.Close()
+	ctx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
+	defer cancel()
 
-	mysqlDB.SetConnMaxLifetime(database.MYSQL_CONN_MAX_LIFETIME)
-	mysqlDB.SetMaxOpenConns(database.MYSQL_MAX_OPEN_CONNS)
-	mysqlDB.SetMaxIdleConns(database.MYSQL_MAX_IDLE_CONNS)
+	mongoDB := deps.Connections.MongoDB()
 
-	if err := mysqlDB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping MySQL: %w", err)
+	state, err := LoadSyncState(ctx, mongoDB, database.COLLECTION_BUDGETS)
+	if err != nil {
+		return err
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), database.MONGODB_TIMEOUT)
-	defer cancel()
-
-	mongoURI := os.Getenv(utils.MONGODB_URI)
-	opts := options.Client().ApplyURI(mongoURI)
-	mongoClient, err := mongo.Connect(opts)
+	statusState, err := LoadSyncState(ctx, mongoDB, "budgets_status")
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return err
 	}
-	defer mongoClient.Disconnect(ctx)
+	startedAt := time.Now()
+	fullSync := run.ForceFullSync || state.NeedsFullSync(startedAt, deps.Config.Sync.FullReconciliationInterval) || statusState.NeedsFullSync(startedAt, deps.Config.Sync.FullReconciliationInterval)
 
-	usersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_USERS)
+	usersCollection := mongoDB.Collection(database.COLLECTION_USERS)
 	userOldIDToObjectID := make(map[uint64]bson.ObjectID)
 	userCursor, err := usersCollection.Find(ctx, bson.D{})
 	if err != nil {
@@ -170,9 +158,26 @@ func SyncBudgets() error {
 	}
 	userCursor.Close(ctx)
 
+	productsCollection := mongoDB.Collection(database.COLLECTION_PRODUCTS)
+	products, err := loadProductRefs(ctx, productsCollection)
+	if err != nil {
+		return err
+	}
+
 	allBudgetsMap := make(map[uint64]*MySQLBudgets)
 
-	dataRows, err := mysqlDB.Query("SELECT id, user_id, cliente_octa_number, nome_cliente, lista_produtos, texto_orcamento, endereco_cep, endereco, opcao_entrega, prazo_opcao_entrega, preco_opcao_entrega, created_at, updated_at, antecipado, data_antecipa, taxa_antecipa, descontado, tipo_desconto, valor_desconto, percentual_desconto, total_orcamento, brinde, produtos_brinde, prazo_producao, prev_entrega FROM orcamentos WHERE id IS NOT NULL")
+	query := "SELECT id, user_id, cliente_octa_number, nome_cliente, lista_produtos, texto_orcamento, endereco_cep, endereco, opcao_entrega, prazo_opcao_entrega, preco_opcao_entrega, created_at, updated_at, antecipado, data_antecipa, taxa_antecipa, descontado, tipo_desconto, valor_desconto, percentual_desconto, total_orcamento, brinde, produtos_brinde, prazo_producao, prev_entrega FROM orcamentos WHERE id IS NOT NULL"
+	var args []any
+	if !fullSync {
+		// A status change (e.g. approval) does not touch orcamentos.updated_at,
+		// so budgets whose orcamentos_status row changed are selected as well.
+		condition, conditionArgs := state.WhereChanged("updated_at", "id")
+		statusCondition, statusConditionArgs := statusState.WhereChanged("updated_at", "id")
+		query += " AND (" + condition + " OR id IN (SELECT orcamento_id FROM orcamentos_status WHERE " + statusCondition + "))"
+		args = append(conditionArgs, statusConditionArgs...)
+	}
+
+	dataRows, err := mysqlDB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to query MySQL orcamentos data: %w", err)
 	}
@@ -197,41 +202,46 @@ func SyncBudgets() error {
 		}
 
 		allBudgetsMap[budget.ID] = budget
+		if budget.UpdatedAt.Valid {
+			state.Advance(budget.UpdatedAt.Time, strconv.FormatUint(budget.ID, 10))
+		}
 	}
 
 	if err = dataRows.Err(); err != nil {
 		return fmt.Errorf("error iterating MySQL data rows: %w", err)
 	}
+	run.Stats.MySQLRows = len(allBudgetsMap)
 
-	allBudgetStatusesMap := make(map[uint64]*MySQLBudgetsStatus)
-	statusRows, err := mysqlDB.Query("SELECT id, user_id, orcamento_id, status, forma_pagamento, tipo_faturamento, data_faturamento, qtd_parcelas, link_trello, comentarios, data_faturamento_2, data_faturamento_3, valor_faturamento, valor_faturamento_2, valor_faturamento_3 FROM orcamentos_status")
-	if err != nil {
-		return fmt.Errorf("failed to query MySQL orcamentos_status data: %w", err)
+	if len(allBudgetsMap) == 0 && !fullSync {
+		return nil
 	}
-	defer statusRows.Close()
 
-	for statusRows.Next() {
-		status := &MySQLBudgetsStatus{}
-		err := statusRows.Scan(
-			&status.ID, &status.UserID, &status.OrcamentoID, &status.Status, &status.FormaPagamento,
-			&status.TipoFaturamento, &status.DataFaturamento, &status.QtdParcelas, &status.LinkTrello,
-			&status.Comentarios, &status.DataFaturamento2, &status.DataFaturamento3,
-			&status.ValorFaturamento, &status.ValorFaturamento2, &status.ValorFaturamento3,
-		)
-		if err != nil {
-			statusRows.Close()
-			return fmt.Errorf("failed to scan MySQL orcamentos_status data: %w", err)
+	allBudgetStatusesMap := make(map[uint64]*MySQLBudgetsStatus)
+	statusQuery := "SELECT id, user_id, orcamento_id, status, forma_pagamento, tipo_faturamento, data_faturamento, qtd_parcelas, link_trello, comentarios, data_faturamento_2, data_faturamento_3, valor_faturamento, valor_faturamento_2, valor_faturamento_3, updated_at FROM orcamentos_status"
+
+	// An incremental run reads the statuses of the changed budgets only, in
+	// chunks that keep the IN list below the placeholder limit of MySQL.
+	statusChunks := [][]any{nil}
+	if !fullSync {
+		changedIDs := make([]any, 0, len(allBudgetsMap))
+		for id := range allBudgetsMap {
+			changedIDs = append(changedIDs, id)
 		}
-		allBudgetStatusesMap[status.OrcamentoID] = status
+		statusChunks = slices.Collect(slices.Chunk(changedIDs, SQL_IN_CHUNK_SIZE))
 	}
 
-	if err = statusRows.Err(); err != nil {
-		return fmt.Errorf("error iterating MySQL orcamentos_status rows: %w", err)
+	for _, ids := range statusChunks {
+		query := statusQuery
+		if ids != nil {
+			query += " WHERE orcamento_id IN (" + sqlPlaceholders(len(ids)) + ")"
+		}
+		if err := readBudgetStatuses(ctx, mysqlDB, query, ids, allBudgetStatusesMap, statusState); err != nil {
+			return err
+		}
 	}
 
 	if len(allBudgetsMap) == 0 {
-		fmt.Printf("[SYNC_BUDGETS] No records found in MySQL to synchronize: %s\n",
-			time.Now().Format("2006-01-02 15:04:05"))
+		run.Logger.Info("no records found in MySQL to synchronize")
 		return nil
 	}
 
@@ -240,11 +250,20 @@ func SyncBudgets() error {
 		mysqlIDs[id] = true
 	}
 
-	budgetsCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_BUDGETS)
+	budgetsCollection := mongoDB.Collection(database.COLLECTION_BUDGETS)
 	mongoIDs := make(map[uint64]bool)
 	mongoBudgetsData := make(map[uint64]MongoDBBudgets)
 
-	cursor, err := budgetsCollection.Find(ctx, bson.D{})
+	mongoFilter := bson.D{}
+	if !fullSync {
+		changedIDs := make([]uint64, 0, len(mysqlIDs))
+		for id := range mysqlIDs {
+			changedIDs = append(changedIDs, id)
+		}
+		mongoFilter = bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: changedIDs}}}}
+	}
+
+	cursor, err := budgetsCollection.Find(ctx, mongoFilter)
 	if err != nil {
 		return fmt.Errorf("failed to query MongoDB budgets: %w", err)
 	}
@@ -255,8 +274,11 @@ func SyncBudgets() error {
 		if err := cursor.Decode(&budget); err != nil {
 			return fmt.Errorf("failed to decode MongoDB budget: %w", err)
 		}
+		run.Stats.MongoDocs++
 		if budget.OldID > 0 {
-			mongoIDs[budget.OldID] = true
+			if budget.DeletedAt == nil {
+				mongoIDs[budget.OldID] = true
+			}
 			mongoBudgetsData[budget.OldID] = budget
 		}
 	}
@@ -266,19 +288,19 @@ func SyncBudgets() error {
 	}
 
 	idsToDelete := []uint64{}
-	for mongoID := range mongoIDs {
-		if !mysqlIDs[mongoID] {
-			idsToDelete = append(idsToDelete, mongoID)
+	if fullSync {
+		for mongoID := range mongoIDs {
+			if !mysqlIDs[mongoID] {
+				idsToDelete = append(idsToDelete, mongoID)
+			}
 		}
 	}
 
-	if len(idsToDelete) > 0 {
-		deleteFilter := bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: idsToDelete}}}}
-		_, err := budgetsCollection.DeleteMany(ctx, deleteFilter)
-		if err != nil {
-			return fmt.Errorf("failed to delete non-existing budgets from MongoDB: %w", err)
-		}
+	deleted, err := reconcileDeletes(ctx, run, budgetsCollection, "old_id", idsToDelete, len(mongoIDs))
+	if err != nil {
+		return err
 	}
+	run.Stats.Deleted = deleted
 
 	recordsToUpsert := make([]uint64, 0)
 	for id := range allBudgetsMap {
@@ -286,20 +308,16 @@ func SyncBudgets() error {
 	}
 
 	if len(recordsToUpsert) == 0 {
-		return nil
+		return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state, statusState)
 	}
 
 	totalRecords := len(recordsToUpsert)
-	batchSize := 50
-	if totalRecords > 1000 {
-		batchSize = 200
-	} else if totalRecords > 5000 {
-		batchSize = 500
-	}
+	batchSize := deps.Config.Sync.BatchSizeFor(totalRecords)
 
 	bulkOperations := []mongo.WriteModel{}
 	processedCount := 0
-	bulkWriteCount := 0
+	skippedCount := 0
+	unparseableCount := 0
 
 	for _, id := range recordsToUpsert {
 		budget := allBudgetsMap[id]
@@ -324,6 +342,15 @@ func SyncBudgets() error {
 
 		if budget.ListaProdutos.Valid {
 			mongoBudget = append(mongoBudget, bson.E{Key: "old_products_list", Value: budget.ListaProdutos.String})
+
+			lines, issues := ParseLegacyProductsList(budget.ListaProdutos.String)
+			mongoBudget = append(mongoBudget,
+				bson.E{Key: "products", Value: products.resolve(lines)},
+				bson.E{Key: "products_parse_errors", Value: issues},
+			)
+			if len(issues) > 0 {
+				unparseableCount++
+			}
 		}
 
 		address := MongoDBAddress{}
@@ -426,8 +453,19 @@ func SyncBudgets() error {
 			mongoBudget = append(mongoBudget, bson.E{Key: "approved", Value: false})
 		}
 
+		hash, err := documentHash(mongoBudget)
+		if err != nil {
+			return fmt.Errorf("failed to hash budget %d: %w", id, err)
+		}
+		if existing, exists := mongoBudgetsData[id]; exists && existing.SyncHash == hash && !existing.DeletedBySync {
+			skippedCount++
+			continue
+		}
+		mongoBudget = append(mongoBudget, bson.E{Key: "sync_hash", Value: hash})
+
 		filter := bson.D{{Key: "old_id", Value: id}}
-		update := bson.D{{Key: "$set", Value: mongoBudget}}
+		update := upsertUpdate(mongoBudget, mongoBudgetsData[id].DeletedBySync)
+		run.Changes.Upsert(id, snapshotOf(mongoBudgetsData, id), mongoBudget)
 
 		upsertModel := mongo.NewUpdateOneModel().
 			SetFilter(filter).
@@ -438,23 +476,60 @@ func SyncBudgets() error {
 		processedCount++
 
 		if len(bulkOperations) >= batchSize {
-			_, err := budgetsCollection.BulkWrite(ctx, bulkOperations)
-			if err != nil {
+			if err := run.BulkWrite(ctx, budgetsCollection, bulkOperations); err != nil {
 				return fmt.Errorf("failed to bulk write to MongoDB: %w", err)
 			}
 
 			bulkOperations = []mongo.WriteModel{}
-			bulkWriteCount++
 		}
 	}
 
 	if len(bulkOperations) > 0 {
-		_, err := budgetsCollection.BulkWrite(ctx, bulkOperations)
-		if err != nil {
+		if err := run.BulkWrite(ctx, budgetsCollection, bulkOperations); err != nil {
 			return fmt.Errorf("failed to bulk write remaining documents to MongoDB: %w", err)
 		}
-		bulkWriteCount++
 	}
 
+	run.Stats.Skipped = skippedCount
+
+	run.Logger.Info("budgets written", "upserted", processedCount, "unchanged", skippedCount)
+
+	if unparseableCount > 0 {
+		run.Logger.Warn("budgets have product rows that could not be parsed, see products_parse_errors", "count", unparseableCount)
+	}
+
+	return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state, statusState)
+}
+
+// readBudgetStatuses adds the orcamentos_status rows of query to statuses,
+// by budget id, advancing the watermark of statusState.
+func readBudgetStatuses(ctx context.Context, mysqlDB *sql.DB, query string, args []any, statuses map[uint64]*MySQLBudgetsStatus, statusState *SyncState) error {
+	statusRows, err := mysqlDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query MySQL orcamentos_status data: %w", err)
+	}
+	defer statusRows.Close()
+
+	for statusRows.Next() {
+		status := &MySQLBudgetsStatus{}
+		err := statusRows.Scan(
+			&status.ID, &status.UserID, &status.OrcamentoID, &status.Status, &status.FormaPagamento,
+			&status.TipoFaturamento, &status.DataFaturamento, &status.QtdParcelas, &status.LinkTrello,
+			&status.Comentarios, &status.DataFaturamento2, &status.DataFaturamento3,
+			&status.ValorFaturamento, &status.ValorFaturamento2, &status.ValorFaturamento3,
+			&status.UpdatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan MySQL orcamentos_status data: %w", err)
+		}
+		statuses[status.OrcamentoID] = status
+		if status.UpdatedAt.Valid {
+			statusState.Advance(status.UpdatedAt.Time, strconv.FormatUint(status.ID, 10))
+		}
+	}
+
+	if err := statusRows.Err(); err != nil {
+		return fmt.Errorf("error iterating MySQL orcamentos_status rows: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database_sync/carriers"
+	"database_sync/config"
+	"database_sync/database"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// finishedOrderStatuses are the statuses after which a parcel is no longer
+// tracked with its carrier.
+var finishedOrderStatuses = []OrderStatus{StatusEntregue, StatusRetirada, StatusDevolucao}
+
+// NewCarrierRegistry returns the providers of every carrier configured with
+// credentials.
+func NewCarrierRegistry(cfg config.CarriersConfig) *carriers.Registry {
+	options := func(baseURL string) carriers.Options {
+		return carriers.Options{BaseURL: baseURL, Timeout: cfg.Timeout}
+	}
+
+	var providers []carriers.TrackingProvider
+	if correios := cfg.Correios; correios.Enabled() {
+		credentials := carriers.CorreiosCredentials{User: correios.User, AccessCode: correios.AccessCode, PostingCard: correios.PostingCard}
+		providers = append(providers, carriers.NewCorreios(credentials, options(correios.BaseURL)))
+	}
+	if jadlog := cfg.Jadlog; jadlog.Enabled() {
+		providers = append(providers, carriers.NewJadlog(jadlog.Token, options(jadlog.BaseURL)))
+	}
+	if loggi := cfg.Loggi; loggi.Enabled() {
+		providers = append(providers, carriers.NewLoggi(loggi.Token, loggi.CompanyID, options(loggi.BaseURL)))
+	}
+	return carriers.NewRegistry(providers...)
+}
+
+// carrierCheckFields is the $unset clearing the bookkeeping of the carrier
+// lookups, for an order that got a new tracking code.
+var carrierCheckFields = bson.D{
+	{Key: "tracking.carrier_attempts", Value: ""},
+	{Key: "tracking.carrier_last_checked_at", Value: ""},
+	{Key: "tracking.carrier_next_check_at", Value: ""},
+	{Key: "tracking.carrier_stopped_at", Value: ""},
+}
+
+// setsNewTrackingCode reports whether set replaces code, the tracking code of
+// an order, with another one.
+func setsNewTrackingCode(code string, set bson.D) bool {
+	return slices.ContainsFunc(set, func(field bson.E) bool {
+		return field.Key == "tracking.code" && field.Value != "" && field.Value != code
+	})
+}
+
+// SyncCarrierTracking follows the orders Tiny gave a tracking code with their
+// carrier, storing the events of the parcel until it is delivered. With
+// carriers.auto_deliver on, a delivered parcel moves its order to Entregue.
+// Lookups are spaced with the backoff and the attempt limit of carriers
+// config, a code the carrier does not know yet counting as a lookup without
+// news.
+func SyncCarrierTracking(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	if deps.Carriers.Empty() {
+		run.Logger.Debug("no carrier is configured, skipping")
+		return nil
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
+	defer cancel()
+
+	ordersCollection := deps.Connections.MongoDB().Collection(database.COLLECTION_ORDERS)
+
+	now := time.Now()
+	filter := bson.D{
+		{Key: "created_at", Value: bson.D{{Key: "$gte", Value: now.Add(-deps.Config.Carriers.Lookback)}}},
+		{Key: "tracking.code", Value: bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: ""}}},
+		{Key: "tracking.delivered_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "tracking.carrier_stopped_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "tracking.carrier_next_check_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "tracking.carrier_next_check_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+		}},
+		{Key: "status", Value: bson.D{{Key: "$nin", Value: finishedOrderStatuses}}},
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	cursor, err := ordersCollection.Find(queryCtx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to query MongoDB orders: %w", err)
+	}
+	defer cursor.Close(queryCtx)
+
+	var ordersToProcess []MongoDBOrders
+	for cursor.Next(queryCtx) {
+		var order MongoDBOrders
+		if err := cursor.Decode(&order); err != nil {
+			return fmt.Errorf("failed to decode MongoDB order: %w", err)
+		}
+		run.Stats.MongoDocs++
+
+		// Orders of carriers without credentials, or picked up in person,
+		// keep what Tiny reports.
+		if deps.Carriers.For(order.Tracking.Service) != nil {
+			ordersToProcess = append(ordersToProcess, order)
+		} else {
+			run.Stats.Skipped++
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("error iterating MongoDB cursor: %w", err)
+	}
+
+	cursor.Close(queryCtx)
+	cancel()
+
+	// As in the tracking job, workers only call the carriers and the results
+	// are applied here, one at a time.
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+
+	pending := make(chan MongoDBOrders)
+	results := make(chan carrierLookup)
+
+	go func() {
+		defer close(pending)
+		for _, order := range ordersToProcess {
+			select {
+			case pending <- order:
+			case <-fetchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for range deps.Config.Carriers.Workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for order := range pending {
+				provider := deps.Carriers.For(order.Tracking.Service)
+				result, err := provider.Track(fetchCtx, order.Tracking.Code)
+				results <- carrierLookup{order: order, carrier: provider.Name(), result: result, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	batchSize := deps.Config.Sync.BatchSizeFor(len(ordersToProcess))
+	bulkOperations := []mongo.WriteModel{}
+	// checkOperations only move the bookkeeping of lookups without news,
+	// they are kept out of the run stats.
+	checkOperations := []mongo.WriteModel{}
+	var writeErr error
+
+	for lookup := range results {
+		if writeErr != nil || ctx.Err() != nil {
+			continue
+		}
+
+		order := lookup.order
+		logger := run.Logger.With("order_id", order.ID.Hex(), "carrier", lookup.carrier)
+
+		// Carriers only know a parcel once it is posted, until then the
+		// lookup counts as one without news.
+		var set bson.D
+		switch {
+		case errors.Is(lookup.err, carriers.ErrNotFound):
+			logger.Debug("tracking code not known by the carrier yet")
+		case lookup.err != nil:
+			logger.Warn("failed to fetch carrier tracking", "error", lookup.err)
+			run.RecordFailure(order.ID.Hex())
+			continue
+		default:
+			set = carrierTrackingUpdate(order, lookup.carrier, lookup.result, deps.Config.Carriers.AutoDeliver)
+		}
+
+		check, stopped := carrierCheck(deps.Config.Carriers, order, set, time.Now())
+		if stopped {
+			logger.Info("no news from the carrier after the maximum number of lookups, no longer tracking the parcel", "attempts", deps.Config.Carriers.MaxAttempts)
+		}
+		operation := mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: order.ID}}).
+			SetUpdate(bson.D{{Key: "$set", Value: append(set, check...)}})
+
+		if set == nil {
+			run.Stats.Skipped++
+			checkOperations = append(checkOperations, operation)
+		} else {
+			bulkOperations = append(bulkOperations, operation)
+			run.Changes.Upsert(order.OldID, order, set)
+		}
+
+		if len(bulkOperations) >= batchSize {
+			if err := run.BulkWrite(ctx, ordersCollection, bulkOperations); err != nil {
+				writeErr = fmt.Errorf("failed to execute bulk write: %w", err)
+				stopFetching()
+			}
+			bulkOperations = []mongo.WriteModel{}
+		}
+		if len(checkOperations) >= batchSize {
+			if err := writeChecks(ctx, run, ordersCollection, checkOperations); err != nil {
+				writeErr = fmt.Errorf("failed to save carrier lookups: %w", err)
+				stopFetching()
+			}
+			checkOperations = []mongo.WriteModel{}
+		}
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+	if len(bulkOperations) > 0 {
+		if err := run.BulkWrite(ctx, ordersCollection, bulkOperations); err != nil {
+			return fmt.Errorf("failed to execute final bulk write: %w", err)
+		}
+	}
+	if len(checkOperations) > 0 {
+		if err := writeChecks(ctx, run, ordersCollection, checkOperations); err != nil {
+			return fmt.Errorf("failed to save carrier lookups: %w", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// carrierCheck returns the bookkeeping of a lookup of order with its carrier,
+// set being what the lookup changed, as trackingCheck does for Tiny.
+func carrierCheck(cfg config.CarriersConfig, order MongoDBOrders, set bson.D, now time.Time) (bson.D, bool) {
+	return backoffCheck("tracking.carrier_", cfg.BackoffConfig, order.Tracking.CarrierAttempts, len(set) > 0, now)
+}
+
+// writeChecks applies bookkeeping updates like run.BulkWrite, without
+// counting them as updated documents.
+func writeChecks(ctx context.Context, run *SyncRun, collection *mongo.Collection, models []mongo.WriteModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if run.DryRun {
+		run.Report.planUpserts(models)
+		return nil
+	}
+
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), run.WriteTimeout)
+	defer cancel()
+	_, err := collection.BulkWrite(writeCtx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// carrierLookup is the answer of a carrier for one order.
+type carrierLookup struct {
+	order   MongoDBOrders
+	carrier string
+	result  *carriers.Result
+	err     error
+}
+
+// carrierTrackingUpdate returns the fields to $set on order, or nil when the
+// carrier reports nothing new. Dotted keys leave the service, url and code
+// Tiny gave untouched.
+func carrierTrackingUpdate(order MongoDBOrders, carrier string, result *carriers.Result, autoDeliver bool) bson.D {
+	last := result.LastEvent()
+	if last == nil {
+		return nil
+	}
+
+	previous := order.Tracking
+	unchanged := previous.Carrier == carrier &&
+		len(previous.Events) == len(result.Events) &&
+		previous.LastStatus == last.Status &&
+		previous.LastEventAt != nil && previous.LastEventAt.Equal(last.At) &&
+		!result.Delivered
+	if unchanged {
+		return nil
+	}
+
+	set := bson.D{
+		{Key: "tracking.carrier", Value: carrier},
+		{Key: "tracking.events", Value: result.Events},
+		{Key: "tracking.last_status", Value: last.Status},
+		{Key: "tracking.last_event_at", Value: last.At},
+	}
+	if result.Delivered {
+		deliveredAt := result.DeliveredAt
+		if deliveredAt.IsZero() {
+			deliveredAt = last.At
+		}
+		set = append(set, bson.E{Key: "tracking.delivered_at", Value: deliveredAt})
+		if autoDeliver {
+			set = append(set, bson.E{Key: "status", Value: StatusEntregue})
+		}
+	}
+	return set
+}
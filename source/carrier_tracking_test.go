@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database_sync/carriers"
+	"database_sync/config"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCarrierCheckStopsUnknownCode(t *testing.T) {
+	cfg := config.Default().Carriers
+	cfg.MaxAttempts = 3
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	order := MongoDBOrders{Tracking: Tracking{Code: "QB123456789BR"}}
+
+	// Every lookup answers carriers.ErrNotFound, which changes nothing.
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		check, stopped := carrierCheck(cfg, order, nil, now)
+		if got := lookup(check, "tracking.carrier_attempts"); got != attempt {
+			t.Fatalf("lookup %d: attempts = %v, want %d", attempt, got, attempt)
+		}
+		order.Tracking.CarrierAttempts = attempt
+
+		if attempt < cfg.MaxAttempts {
+			next := lookup(check, "tracking.carrier_next_check_at").(time.Time)
+			if stopped || !next.Equal(now.Add(cfg.NextCheck(attempt))) {
+				t.Errorf("lookup %d = %v, want the next lookup in %v", attempt, check, cfg.NextCheck(attempt))
+			}
+			continue
+		}
+		if !stopped || lookup(check, "tracking.carrier_stopped_at") == nil {
+			t.Errorf("lookup %d = %v, want the parcel no longer tracked", attempt, check)
+		}
+	}
+}
+
+func TestCarrierCheckResetsOnNewEvent(t *testing.T) {
+	cfg := config.Default().Carriers
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	order := MongoDBOrders{Tracking: Tracking{Code: "QB123456789BR", CarrierAttempts: 6}}
+
+	result := &carriers.Result{Events: []carriers.Event{{At: now.Add(-time.Hour), Status: "RO", Description: "Objeto em trânsito"}}}
+	set := carrierTrackingUpdate(order, "correios", result, false)
+	check, stopped := carrierCheck(cfg, order, set, now)
+	if stopped || lookup(check, "tracking.carrier_attempts") != 0 {
+		t.Errorf("check = %v, want the attempts reset by the new event", check)
+	}
+}
+
+func TestSetsNewTrackingCode(t *testing.T) {
+	tests := []struct {
+		code string
+		set  bson.D
+		want bool
+	}{
+		{code: "", set: bson.D{{Key: "tracking.code", Value: "QB123456789BR"}}, want: true},
+		{code: "QB000000000BR", set: bson.D{{Key: "tracking.code", Value: "QB123456789BR"}}, want: true},
+		{code: "QB123456789BR", set: bson.D{{Key: "tracking.code", Value: "QB123456789BR"}, {Key: "tiny.situacao", Value: "Enviado"}}},
+		{code: "QB123456789BR", set: bson.D{{Key: "tracking.code", Value: ""}}},
+		{code: "", set: bson.D{{Key: "tracking.service", Value: "Correios SEDEX"}}},
+	}
+	for _, test := range tests {
+		if got := setsNewTrackingCode(test.code, test.set); got != test.want {
+			t.Errorf("setsNewTrackingCode(%q, %v) = %v, want %v", test.code, test.set, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package carriers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CORREIOS_BASE_URL = "https://api.correios.com.br"
+
+	// correiosTokenMargin renews the token a little before it expires.
+	correiosTokenMargin = 5 * time.Minute
+)
+
+// correiosDeliveredCodes are the SRO event codes of a delivered object:
+// delivered to the addressee, to a mailbox and at a pickup point. Each of
+// them means delivery only with type 01.
+var correiosDeliveredCodes = []string{"BDE", "BDI", "BDR"}
+
+// CorreiosCredentials are those of the Correios API portal: the user, the
+// access code generated there and the posting card of the contract.
+type CorreiosCredentials struct {
+	User        string
+	AccessCode  string
+	PostingCard string
+}
+
+// Correios tracks objects with the SRO Rastro API. Its bearer tokens last a
+// day, so one is requested with the credentials and reused until it expires.
+type Correios struct {
+	options     Options
+	credentials CorreiosCredentials
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewCorreios(credentials CorreiosCredentials, options Options) *Correios {
+	return &Correios{options: options.withDefaults(CORREIOS_BASE_URL), credentials: credentials}
+}
+
+func (c *Correios) Name() string {
+	return "correios"
+}
+
+func (c *Correios) Matches(service string) bool {
+	return matchesAny(service, "correios", "sedex", "pac", "impresso", "carta")
+}
+
+type correiosObject struct {
+	Objetos []struct {
+		CodObjeto string `json:"codObjeto"`
+		Mensagem  string `json:"mensagem"`
+		Eventos   []struct {
+			Codigo     string `json:"codigo"`
+			Tipo       string `json:"tipo"`
+			DtHrCriado string `json:"dtHrCriado"`
+			Descricao  string `json:"descricao"`
+			Unidade    struct {
+				Endereco struct {
+					Cidade string `json:"cidade"`
+					UF     string `json:"uf"`
+				} `json:"endereco"`
+			} `json:"unidade"`
+		} `json:"eventos"`
+	} `json:"objetos"`
+}
+
+func (c *Correios) Track(ctx context.Context, code string) (*Result, error) {
+	token, err := c.bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response correiosObject
+	endpoint := c.options.BaseURL + "/srorastro/v1/objetos/" + url.PathEscape(code) + "?resultado=T"
+	if err := doJSON(ctx, c.options, c.Name(), http.MethodGet, endpoint, bearer(token), nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Objetos) == 0 || (len(response.Objetos[0].Eventos) == 0 && response.Objetos[0].Mensagem != "") {
+		return nil, ErrNotFound
+	}
+
+	// The API lists the most recent event first.
+	result := &Result{}
+	events := response.Objetos[0].Eventos
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		at, err := time.ParseInLocation("2006-01-02T15:04:05", event.DtHrCriado, saoPaulo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Correios event time %q: %w", event.DtHrCriado, err)
+		}
+
+		location := event.Unidade.Endereco.Cidade
+		if uf := event.Unidade.Endereco.UF; uf != "" {
+			location = strings.TrimPrefix(location+"/"+uf, "/")
+		}
+
+		status := event.Codigo + "-" + event.Tipo
+		result.Events = append(result.Events, Event{At: at, Status: status, Description: event.Descricao, Location: location})
+
+		if event.Tipo == "01" && slices.Contains(correiosDeliveredCodes, event.Codigo) {
+			result.Delivered = true
+			result.DeliveredAt = at
+		}
+	}
+	return result, nil
+}
+
+func (c *Correios) bearerToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expiresAt.Add(-correiosTokenMargin)) {
+		return c.token, nil
+	}
+
+	var response struct {
+		Token    string `json:"token"`
+		ExpiraEm string `json:"expiraEm"`
+	}
+	basic := base64.StdEncoding.EncodeToString([]byte(c.credentials.User + ":" + c.credentials.AccessCode))
+	header := http.Header{"Authorization": {"Basic " + basic}}
+
+	endpoint := c.options.BaseURL + "/token/v1/autentica/cartaopostagem"
+	body := map[string]string{"numero": c.credentials.PostingCard}
+	if err := doJSON(ctx, c.options, c.Name(), http.MethodPost, endpoint, header, body, &response); err != nil {
+		return "", fmt.Errorf("failed to authenticate on Correios: %w", err)
+	}
+
+	expiresAt, err := time.ParseInLocation("2006-01-02T15:04:05", response.ExpiraEm, saoPaulo)
+	if err != nil {
+		expiresAt = time.Now().Add(time.Hour)
+	}
+	c.token, c.expiresAt = response.Token, expiresAt
+	return c.token, nil
+}
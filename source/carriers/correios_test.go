@@ -0,0 +1,115 @@
+package carriers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCorreiosServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token/v1/autentica/cartaopostagem", func(w http.ResponseWriter, r *http.Request) {
+		user, accessCode, ok := r.BasicAuth()
+		var body struct {
+			Numero string `json:"numero"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if !ok || user != "loja" || accessCode != "access-code" || body.Numero != "0075000001" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeFixture(t, w, "correios_token.json")
+	})
+	mux.HandleFunc("GET /srorastro/v1/objetos/{code}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer correios-bearer-token" || r.URL.Query().Get("resultado") != "T" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.PathValue("code") {
+		case "QB123456789BR":
+			writeFixture(t, w, "correios_objeto.json")
+		case "QB111111111BR":
+			writeFixture(t, w, "correios_objeto_em_transito.json")
+		case "QB000000000BR":
+			writeFixture(t, w, "correios_objeto_nao_encontrado.json")
+		case "QB500000000BR":
+			w.WriteHeader(http.StatusBadGateway)
+		case "QB999999999BR":
+			w.Write([]byte(`{"objetos": [{"codObjeto": `))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCorreiosTrack(t *testing.T) {
+	server := newCorreiosServer(t)
+	credentials := CorreiosCredentials{User: "loja", AccessCode: "access-code", PostingCard: "0075000001"}
+	correios := NewCorreios(credentials, Options{BaseURL: server.URL + "/"})
+
+	t.Run("delivered", func(t *testing.T) {
+		result, err := correios.Track(context.Background(), "QB123456789BR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEvents(t, result, []Event{
+			{At: brt(2024, 5, 6, 16, 47, 30), Status: "PO-01", Description: "Objeto postado", Location: "SAO PAULO/SP"},
+			{At: brt(2024, 5, 7, 18, 20, 1), Status: "RO-01", Description: "Objeto em transferência - por favor aguarde", Location: "SAO PAULO/SP"},
+			{At: brt(2024, 5, 9, 8, 5, 44), Status: "OEC-01", Description: "Objeto saiu para entrega ao destinatário", Location: "CAMPINAS/SP"},
+			{At: brt(2024, 5, 9, 14, 32, 10), Status: "BDE-01", Description: "Objeto entregue ao destinatário", Location: "CAMPINAS/SP"},
+		})
+		if !result.Delivered || !result.DeliveredAt.Equal(brt(2024, 5, 9, 14, 32, 10)) {
+			t.Errorf("delivered = %v at %v, want the BDE-01 event", result.Delivered, result.DeliveredAt)
+		}
+	})
+
+	t.Run("failed delivery attempt", func(t *testing.T) {
+		result, err := correios.Track(context.Background(), "QB111111111BR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Delivered || len(result.Events) != 2 || result.LastEvent().Status != "BDE-20" {
+			t.Errorf("result = %+v, want BDE-20 as the last event, not delivered", result)
+		}
+	})
+
+	for _, code := range []string{"QB000000000BR", "QB404000000BR"} {
+		t.Run("not found "+code, func(t *testing.T) {
+			if _, err := correios.Track(context.Background(), code); !errors.Is(err, ErrNotFound) {
+				t.Errorf("error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	t.Run("server error", func(t *testing.T) {
+		_, err := correios.Track(context.Background(), "QB500000000BR")
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway || httpErr.Carrier != "correios" {
+			t.Errorf("error = %v, want HTTP 502 from correios", err)
+		}
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		if _, err := correios.Track(context.Background(), "QB999999999BR"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("error = %v, want a parse error", err)
+		}
+	})
+}
+
+func TestCorreiosRejectedCredentials(t *testing.T) {
+	server := newCorreiosServer(t)
+	correios := NewCorreios(CorreiosCredentials{User: "loja", AccessCode: "wrong", PostingCard: "0075000001"}, Options{BaseURL: server.URL})
+
+	_, err := correios.Track(context.Background(), "QB123456789BR")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("error = %v, want the authentication's HTTP 401", err)
+	}
+}
@@ -0,0 +1,77 @@
+package carriers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MAX_RESPONSE_SIZE bounds what is read from a carrier, tracking timelines are
+// a few kilobytes.
+const MAX_RESPONSE_SIZE = 1 << 20
+
+// HTTPError is a carrier answer with a non-2xx status.
+type HTTPError struct {
+	Carrier    string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s answered HTTP %d", e.Carrier, e.StatusCode)
+}
+
+// doJSON sends body, when not nil, as JSON and decodes the response into
+// result. A 404 becomes ErrNotFound.
+func doJSON(ctx context.Context, options Options, carrier, method, url string, header http.Header, body, result any) error {
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s request: %w", carrier, err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build %s request: %w", carrier, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := options.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", carrier, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPError{Carrier: carrier, StatusCode: resp.StatusCode}
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MAX_RESPONSE_SIZE)).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", carrier, err)
+	}
+	return nil
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
@@ -0,0 +1,80 @@
+package carriers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const JADLOG_BASE_URL = "https://www.jadlog.com.br/embarcador/api"
+
+// Jadlog tracks shipments with the embarcador API, authenticated by the token
+// issued to the contract.
+type Jadlog struct {
+	options Options
+	token   string
+}
+
+func NewJadlog(token string, options Options) *Jadlog {
+	return &Jadlog{options: options.withDefaults(JADLOG_BASE_URL), token: token}
+}
+
+func (j *Jadlog) Name() string {
+	return "jadlog"
+}
+
+func (j *Jadlog) Matches(service string) bool {
+	return matchesAny(service, "jadlog")
+}
+
+type jadlogResponse struct {
+	Consulta []struct {
+		Codigo string `json:"codigo"`
+		Error  *struct {
+			ID        int    `json:"id"`
+			Descricao string `json:"descricao"`
+		} `json:"error"`
+		Tracking struct {
+			Status  string `json:"status"`
+			Eventos []struct {
+				Data    string `json:"data"`
+				Status  string `json:"status"`
+				Unidade string `json:"unidade"`
+			} `json:"eventos"`
+		} `json:"tracking"`
+	} `json:"consulta"`
+}
+
+func (j *Jadlog) Track(ctx context.Context, code string) (*Result, error) {
+	var response jadlogResponse
+	body := map[string]any{"consulta": []map[string]string{{"codigo": code}}}
+	if err := doJSON(ctx, j.options, j.Name(), http.MethodPost, j.options.BaseURL+"/tracking/consultar", bearer(j.token), body, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Consulta) == 0 || response.Consulta[0].Error != nil {
+		return nil, ErrNotFound
+	}
+
+	// Events come oldest first, the shipment status is that of the last one.
+	result := &Result{}
+	for _, event := range response.Consulta[0].Tracking.Eventos {
+		at, err := time.ParseInLocation("2006-01-02 15:04:05", event.Data, saoPaulo)
+		if err != nil {
+			// Older shipments only carry the date.
+			if at, err = time.ParseInLocation("2006-01-02", event.Data, saoPaulo); err != nil {
+				continue
+			}
+		}
+		result.Events = append(result.Events, Event{At: at, Status: event.Status, Description: event.Status, Location: event.Unidade})
+
+		if strings.EqualFold(event.Status, "ENTREGUE") {
+			result.Delivered = true
+			result.DeliveredAt = at
+		}
+	}
+	if strings.EqualFold(response.Consulta[0].Tracking.Status, "ENTREGUE") {
+		result.Delivered = true
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package carriers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newJadlogServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tracking/consultar" || r.Header.Get("Authorization") != "Bearer jadlog-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Consulta []struct {
+				Codigo string `json:"codigo"`
+			} `json:"consulta"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Consulta) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch body.Consulta[0].Codigo {
+		case "10082900000123":
+			writeFixture(t, w, "jadlog_tracking.json")
+		case "10082900000999":
+			writeFixture(t, w, "jadlog_nao_encontrado.json")
+		case "10082900000500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`<html>Manutenção</html>`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestJadlogTrack(t *testing.T) {
+	server := newJadlogServer(t)
+	jadlog := NewJadlog("jadlog-token", Options{BaseURL: server.URL})
+
+	t.Run("delivered", func(t *testing.T) {
+		result, err := jadlog.Track(context.Background(), "10082900000123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The event without a valid date is left out.
+		checkEvents(t, result, []Event{
+			{At: brt(2024, 5, 6, 17, 2, 11), Status: "EMISSAO", Description: "EMISSAO", Location: "SAO PAULO 01"},
+			{At: brt(2024, 5, 7, 6, 40, 0), Status: "TRANSFERENCIA", Description: "TRANSFERENCIA", Location: "CD CAJAMAR"},
+			{At: brt(2024, 5, 8, 0, 0, 0), Status: "EM ROTA", Description: "EM ROTA", Location: "CAMPINAS 02"},
+			{At: brt(2024, 5, 8, 15, 25, 43), Status: "ENTREGUE", Description: "ENTREGUE", Location: "CAMPINAS 02"},
+		})
+		if !result.Delivered || !result.DeliveredAt.Equal(brt(2024, 5, 8, 15, 25, 43)) {
+			t.Errorf("delivered = %v at %v, want the ENTREGUE event", result.Delivered, result.DeliveredAt)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := jadlog.Track(context.Background(), "10082900000999"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		_, err := jadlog.Track(context.Background(), "10082900000500")
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError || httpErr.Carrier != "jadlog" {
+			t.Errorf("error = %v, want HTTP 500 from jadlog", err)
+		}
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		if _, err := jadlog.Track(context.Background(), "10082900000000"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("error = %v, want a parse error", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package carriers
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const LOGGI_BASE_URL = "https://api.loggi.com"
+
+// Loggi tracks packages with the Loggi platform API. The token is the one of
+// the integration, and every package belongs to the company.
+type Loggi struct {
+	options   Options
+	token     string
+	companyID string
+}
+
+func NewLoggi(token, companyID string, options Options) *Loggi {
+	return &Loggi{options: options.withDefaults(LOGGI_BASE_URL), token: token, companyID: companyID}
+}
+
+func (l *Loggi) Name() string {
+	return "loggi"
+}
+
+func (l *Loggi) Matches(service string) bool {
+	return matchesAny(service, "loggi")
+}
+
+type loggiResponse struct {
+	Packages []struct {
+		TrackingCode string `json:"trackingCode"`
+		Status       struct {
+			HighLevelStatus string `json:"highLevelStatus"`
+		} `json:"status"`
+		TrackingHistory []struct {
+			Status struct {
+				Code            string    `json:"code"`
+				HighLevelStatus string    `json:"highLevelStatus"`
+				Description     string    `json:"description"`
+				UpdatedTime     time.Time `json:"updatedTime"`
+			} `json:"status"`
+			Location struct {
+				City  string `json:"city"`
+				State string `json:"state"`
+			} `json:"location"`
+		} `json:"trackingHistory"`
+	} `json:"packages"`
+}
+
+func (l *Loggi) Track(ctx context.Context, code string) (*Result, error) {
+	var response loggiResponse
+	endpoint := l.options.BaseURL + "/v1/companies/" + url.PathEscape(l.companyID) + "/packages/" + url.PathEscape(code) + "/tracking"
+	if err := doJSON(ctx, l.options, l.Name(), http.MethodGet, endpoint, bearer(l.token), nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response.Packages) == 0 {
+		return nil, ErrNotFound
+	}
+
+	pkg := response.Packages[0]
+	result := &Result{}
+	for _, history := range pkg.TrackingHistory {
+		status := history.Status
+		location := strings.TrimPrefix(strings.TrimSuffix(history.Location.City+"/"+history.Location.State, "/"), "/")
+		result.Events = append(result.Events, Event{At: status.UpdatedTime, Status: status.Code, Description: status.Description, Location: location})
+
+		if loggiDelivered(status.HighLevelStatus) {
+			result.Delivered = true
+			result.DeliveredAt = status.UpdatedTime
+		}
+	}
+	if loggiDelivered(pkg.Status.HighLevelStatus) {
+		result.Delivered = true
+	}
+	return result, nil
+}
+
+// loggiDelivered accepts HIGH_LEVEL_STATUS_DELIVERED as well as the short
+// form some accounts get.
+func loggiDelivered(status string) bool {
+	return strings.HasSuffix(strings.ToUpper(status), "DELIVERED")
+}
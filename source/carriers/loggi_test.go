@@ -0,0 +1,89 @@
+package carriers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newLoggiServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/companies/{company}/packages/{code}/tracking", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("company") != "42" || r.Header.Get("Authorization") != "Bearer loggi-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.PathValue("code") {
+		case "LGI123456789":
+			writeFixture(t, w, "loggi_tracking.json")
+		case "LGI000000001":
+			writeFixture(t, w, "loggi_tracking_entregue.json")
+		case "LGI000000000":
+			writeFixture(t, w, "loggi_nao_encontrado.json")
+		case "LGI999999999":
+			w.Write([]byte(`{"packages": [{"trackingHistory": [{"status": {"updatedTime": "ontem"}}]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoggiTrack(t *testing.T) {
+	server := newLoggiServer(t)
+	loggi := NewLoggi("loggi-token", "42", Options{BaseURL: server.URL})
+
+	t.Run("in transit", func(t *testing.T) {
+		result, err := loggi.Track(context.Background(), "LGI123456789")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEvents(t, result, []Event{
+			{At: time.Date(2024, 5, 7, 13, 0, 0, 0, time.UTC), Status: "1", Description: "Pacote coletado", Location: "São Paulo/SP"},
+			{At: time.Date(2024, 5, 8, 11, 10, 0, 0, time.UTC), Status: "4", Description: "Pacote a caminho", Location: "SP"},
+		})
+		if result.Delivered {
+			t.Error("a package in transit is reported delivered")
+		}
+	})
+
+	t.Run("delivered", func(t *testing.T) {
+		result, err := loggi.Track(context.Background(), "LGI000000001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		deliveredAt := time.Date(2024, 5, 9, 17, 45, 12, 0, time.UTC)
+		if !result.Delivered || !result.DeliveredAt.Equal(deliveredAt) || result.LastEvent().Location != "Campinas/SP" {
+			t.Errorf("result = %+v, want delivered at %v in Campinas/SP", result, deliveredAt)
+		}
+	})
+
+	for _, code := range []string{"LGI000000000", "LGI404040404"} {
+		t.Run("not found "+code, func(t *testing.T) {
+			if _, err := loggi.Track(context.Background(), code); !errors.Is(err, ErrNotFound) {
+				t.Errorf("error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+
+	t.Run("rejected token", func(t *testing.T) {
+		other := NewLoggi("loggi-token", "7", Options{BaseURL: server.URL})
+		_, err := other.Track(context.Background(), "LGI123456789")
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden || httpErr.Carrier != "loggi" {
+			t.Errorf("error = %v, want HTTP 403 from loggi", err)
+		}
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		if _, err := loggi.Track(context.Background(), "LGI999999999"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("error = %v, want a parse error", err)
+		}
+	})
+}
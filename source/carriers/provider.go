@@ -0,0 +1,108 @@
+package carriers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("tracking code not found by the carrier")
+
+// saoPaulo is the zone carriers give their local times in. Brazil has had no
+// daylight saving time since 2019, and a fixed zone does not need tzdata in
+// the container.
+var saoPaulo = time.FixedZone("BRT", -3*60*60)
+
+// Event is one step of a parcel as reported by its carrier. Status keeps the
+// carrier's own code, so nothing is lost when it has no equivalent elsewhere.
+type Event struct {
+	At          time.Time `json:"at" bson:"at"`
+	Status      string    `json:"status" bson:"status"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Location    string    `json:"location,omitempty" bson:"location,omitempty"`
+}
+
+// Result is the tracking timeline of one parcel, oldest event first.
+type Result struct {
+	Events    []Event
+	Delivered bool
+	// DeliveredAt is the time of the delivery event, zero until delivered.
+	DeliveredAt time.Time
+}
+
+// LastEvent returns the most recent event, nil when there is none yet.
+func (r *Result) LastEvent() *Event {
+	if len(r.Events) == 0 {
+		return nil
+	}
+	return &r.Events[len(r.Events)-1]
+}
+
+// TrackingProvider looks parcels up on one carrier. Matches tells whether a
+// shipping service, as Tiny names it in forma_frete, belongs to the carrier.
+type TrackingProvider interface {
+	Name() string
+	Matches(service string) bool
+	Track(ctx context.Context, code string) (*Result, error)
+}
+
+// Options are shared by every provider. BaseURL and HTTPClient can point a
+// provider at a fake server or at recorded fixtures.
+type Options struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Timeout    time.Duration
+}
+
+func (o Options) withDefaults(baseURL string) Options {
+	if o.BaseURL == "" {
+		o.BaseURL = baseURL
+	}
+	o.BaseURL = strings.TrimSuffix(o.BaseURL, "/")
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	return o
+}
+
+// Registry picks the provider of an order from its shipping service.
+type Registry struct {
+	providers []TrackingProvider
+}
+
+func NewRegistry(providers ...TrackingProvider) *Registry {
+	return &Registry{providers: providers}
+}
+
+// For returns the provider handling service, or nil when no configured
+// carrier does, as for "Retirar Pessoalmente".
+func (r *Registry) For(service string) TrackingProvider {
+	for _, provider := range r.providers {
+		if provider.Matches(service) {
+			return provider
+		}
+	}
+	return nil
+}
+
+func (r *Registry) Empty() bool {
+	return len(r.providers) == 0
+}
+
+// matchesAny reports whether service contains one of the keywords as a whole
+// word, so "PAC" matches "Correios PAC" but not "Impacto".
+func matchesAny(service string, keywords ...string) bool {
+	words := strings.FieldsFunc(strings.ToLower(service), func(r rune) bool {
+		return !('a' <= r && r <= 'z') && !('0' <= r && r <= '9')
+	})
+	for _, word := range words {
+		for _, keyword := range keywords {
+			if word == keyword {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package carriers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixture returns a response recorded from the carrier, kept in testdata.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func writeFixture(t *testing.T, w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(fixture(t, name))
+}
+
+// brt builds a time in the zone carriers report local times in.
+func brt(year int, month time.Month, day, hour, minute, second int) time.Time {
+	return time.Date(year, month, day, hour, minute, second, 0, saoPaulo)
+}
+
+func checkEvents(t *testing.T, result *Result, want []Event) {
+	t.Helper()
+	if len(result.Events) != len(want) {
+		t.Fatalf("events = %+v, want %d of them", result.Events, len(want))
+	}
+	for i, event := range result.Events {
+		if !event.At.Equal(want[i].At) || event.Status != want[i].Status ||
+			event.Description != want[i].Description || event.Location != want[i].Location {
+			t.Errorf("event %d = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func TestRegistryFor(t *testing.T) {
+	registry := NewRegistry(
+		NewCorreios(CorreiosCredentials{}, Options{}),
+		NewJadlog("", Options{}),
+		NewLoggi("", "", Options{}),
+	)
+
+	tests := map[string]string{
+		"Correios PAC":         "correios",
+		"SEDEX 10":             "correios",
+		"Jadlog .Package":      "jadlog",
+		"LOGGI":                "loggi",
+		"Impacto Transportes":  "",
+		"Retirar Pessoalmente": "",
+		"":                     "",
+	}
+	for service, want := range tests {
+		provider := registry.For(service)
+		got := ""
+		if provider != nil {
+			got = provider.Name()
+		}
+		if got != want {
+			t.Errorf("For(%q) = %q, want %q", service, got, want)
+		}
+	}
+
+	if !NewRegistry().Empty() || registry.Empty() {
+		t.Error("Empty does not match the providers")
+	}
+}
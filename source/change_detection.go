@@ -0,0 +1,22 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// documentHash fingerprints the fields the synchronizer writes for a record.
+// It is stored as sync_hash so the next cycle can tell whether the MySQL row
+// produced a different document without comparing field by field.
+func documentHash(doc bson.D) (string, error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal document for hashing: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
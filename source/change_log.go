@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database_sync/database"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	ChangeInsert     = "insert"
+	ChangeUpdate     = "update"
+	ChangeDelete     = "delete"
+	ChangeSoftDelete = "soft_delete"
+
+	SYNC_CHANGES_TTL_INDEX = "at_ttl"
+)
+
+type FieldChange struct {
+	Field  string `json:"field" bson:"field"`
+	Before any    `json:"before" bson:"before"`
+	After  any    `json:"after" bson:"after"`
+}
+
+// SyncChange is one write issued by a sync job. OldID holds the key the job
+// matches documents by, which is platform_id for leads and old_id otherwise.
+type SyncChange struct {
+	RunID     string        `json:"run_id" bson:"run_id"`
+	Entity    string        `json:"entity" bson:"entity"`
+	OldID     any           `json:"old_id" bson:"old_id"`
+	Operation string        `json:"operation" bson:"operation"`
+	Changes   []FieldChange `json:"changes,omitempty" bson:"changes,omitempty"`
+	At        time.Time     `json:"at" bson:"at"`
+}
+
+// ChangeLog buffers the changes of a run until the bulk write that applies
+// them succeeded. A nil *ChangeLog is valid and records nothing, which is
+// what jobs get when SYNC_CHANGES is off.
+type ChangeLog struct {
+	run        *SyncRun
+	collection *mongo.Collection
+	pending    []any
+}
+
+func NewChangeLog(db *database.MongoDB, run *SyncRun) *ChangeLog {
+	return &ChangeLog{run: run, collection: db.Collection(database.COLLECTION_SYNC_CHANGES)}
+}
+
+// Upsert records the fields of after whose value differs from the snapshot
+// the job loaded before writing. before is nil when the document is new.
+// Only the keys present in after are compared, since $set leaves the others
+// untouched.
+func (l *ChangeLog) Upsert(oldID, before, after any) {
+	if l == nil {
+		return
+	}
+
+	afterRaw, err := bson.Marshal(after)
+	if err != nil {
+		l.run.Logger.Warn("failed to marshal document for the change log", "old_id", oldID, "error", err)
+		return
+	}
+
+	operation := ChangeInsert
+	var beforeRaw bson.Raw
+	if before != nil {
+		operation = ChangeUpdate
+		if beforeRaw, err = bson.Marshal(before); err != nil {
+			l.run.Logger.Warn("failed to marshal snapshot for the change log", "old_id", oldID, "error", err)
+			return
+		}
+	}
+
+	changes, err := diffFields(beforeRaw, afterRaw)
+	if err != nil {
+		l.run.Logger.Warn("failed to read document for the change log", "old_id", oldID, "error", err)
+		return
+	}
+
+	if beforeRaw != nil {
+		if deletedBySync, ok := beforeRaw.Lookup("deleted_by_sync").BooleanOK(); ok && deletedBySync {
+			changes = append(changes, FieldChange{Field: "deleted_at", Before: beforeRaw.Lookup("deleted_at"), After: nil})
+		}
+	}
+
+	if len(changes) == 0 {
+		return
+	}
+	l.add(oldID, operation, changes)
+}
+
+// diffFields returns the fields of after whose value differs in before, which
+// is nil for a new document. A field missing from before equals a zero value,
+// since the omitempty fields of a snapshot leave their zero values out.
+func diffFields(before, after bson.Raw) ([]FieldChange, error) {
+	elements, err := after.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FieldChange{}
+	for _, element := range elements {
+		field := element.Key()
+		if field == "sync_hash" {
+			continue
+		}
+
+		afterValue := element.Value()
+		var beforeValue any
+		if before != nil {
+			// A dotted $set key, such as tracking.code, is compared with the
+			// nested field it updates.
+			value, err := before.LookupErr(strings.Split(field, ".")...)
+			switch {
+			case err == nil && value.Equal(afterValue):
+				continue
+			case (err != nil || isZeroValue(value)) && isZeroValue(afterValue):
+				continue
+			case err == nil:
+				beforeValue = value
+			}
+		}
+
+		changes = append(changes, FieldChange{Field: field, Before: beforeValue, After: afterValue})
+	}
+	return changes, nil
+}
+
+// isZeroValue reports whether value is null or the zero value of its type.
+func isZeroValue(value bson.RawValue) bool {
+	switch value.Type {
+	case bson.TypeNull, bson.TypeUndefined:
+		return true
+	case bson.TypeString:
+		return value.StringValue() == ""
+	case bson.TypeBoolean:
+		return !value.Boolean()
+	case bson.TypeInt32:
+		return value.Int32() == 0
+	case bson.TypeInt64:
+		return value.Int64() == 0
+	case bson.TypeDouble:
+		return value.Double() == 0
+	case bson.TypeDateTime:
+		return value.Time().IsZero()
+	case bson.TypeArray:
+		values, err := value.Array().Values()
+		return err == nil && len(values) == 0
+	case bson.TypeEmbeddedDocument:
+		elements, err := value.Document().Elements()
+		return err == nil && len(elements) == 0
+	}
+	return false
+}
+
+// changesAnything reports whether $set would modify document, so jobs that
+// refresh a document from an API can skip the writes that change nothing.
+func changesAnything(document any, set bson.D) (bool, error) {
+	before, err := bson.Marshal(document)
+	if err != nil {
+		return false, err
+	}
+	after, err := bson.Marshal(set)
+	if err != nil {
+		return false, err
+	}
+	changes, err := diffFields(before, after)
+	return len(changes) > 0, err
+}
+
+func (l *ChangeLog) Delete(oldID any, soft bool) {
+	if l == nil {
+		return
+	}
+
+	if soft {
+		l.add(oldID, ChangeSoftDelete, []FieldChange{{Field: "deleted_at", Before: nil, After: time.Now()}})
+		return
+	}
+	l.add(oldID, ChangeDelete, nil)
+}
+
+func (l *ChangeLog) add(oldID any, operation string, changes []FieldChange) {
+	l.pending = append(l.pending, SyncChange{
+		RunID:     l.run.ID,
+		Entity:    l.run.Entity,
+		OldID:     oldID,
+		Operation: operation,
+		Changes:   changes,
+		At:        time.Now(),
+	})
+}
+
+// Flush stores the buffered changes. Jobs call it after every successful bulk
+// write. A failure here only loses audit entries, so it is logged instead of
+// failing a run whose data was already written.
+func (l *ChangeLog) Flush(ctx context.Context) {
+	if l == nil || len(l.pending) == 0 {
+		return
+	}
+
+	if _, err := l.collection.InsertMany(ctx, l.pending, options.InsertMany().SetOrdered(false)); err != nil {
+		l.run.Logger.Warn("failed to save sync changes", "count", len(l.pending), "error", err)
+	}
+	l.pending = nil
+}
+
+// snapshotOf returns the document a job loaded for id, or nil when there was
+// none, as ChangeLog.Upsert expects.
+func snapshotOf[K comparable, V any](snapshots map[K]V, id K) any {
+	if snapshot, exists := snapshots[id]; exists {
+		return snapshot
+	}
+	return nil
+}
+
+// EnsureSyncChangeIndexes indexes the change log by record and expires it
+// with the same retention as the run history.
+func EnsureSyncChangeIndexes(ctx context.Context, db *database.MongoDB, retention time.Duration) error {
+	collection := db.Collection(database.COLLECTION_SYNC_CHANGES)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "old_id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "run_id", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create sync_changes indexes: %w", err)
+	}
+
+	return ensureTTLIndex(ctx, db, database.COLLECTION_SYNC_CHANGES, "at", SYNC_CHANGES_TTL_INDEX, retention)
+}
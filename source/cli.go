@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+
+	ALL_ENTITIES = "all"
+)
+
+type CLIOptions struct {
+	DryRun     bool
+	ConfigFile string
+}
+
+// CLICommand is one subcommand of the binary. When TakesEntity is set the
+// first argument must name a job or "all", and it defaults to "all" when
+// MinArgs is zero.
+type CLICommand struct {
+	Name        string
+	Usage       string
+	Help        string
+	MinArgs     int
+	MaxArgs     int
+	TakesEntity bool
+	Run         func(ctx context.Context, scheduler *Scheduler, args []string) int
+}
+
+var cliCommands = []*CLICommand{
+	{
+		Name:  "daemon",
+		Usage: "daemon",
+		Help:  "serve HTTP and run every job on its schedule (default)",
+		Run:   runDaemon,
+	},
+	{
+		Name:        "sync",
+		Usage:       "sync <entity|all>",
+		Help:        "run one job, without its dependencies, or one full cycle, then exit",
+		MinArgs:     1,
+		MaxArgs:     1,
+		TakesEntity: true,
+		Run:         runSync,
+	},
+	{
+		Name:  "status",
+		Usage: "status",
+		Help:  "print the last run of every job from the run history",
+		Run:   runStatus,
+	},
+	{
+		Name:        "diff",
+		Usage:       "diff <entity|all>",
+		Help:        "same as sync --dry-run: print what would be written",
+		MinArgs:     1,
+		MaxArgs:     1,
+		TakesEntity: true,
+		Run:         runDiff,
+	},
+	{
+		Name:        "reconcile",
+		Usage:       "reconcile [entity|all]",
+		Help:        "run a full reconciliation, ignoring the incremental watermarks",
+		MaxArgs:     1,
+		TakesEntity: true,
+		Run:         runReconcile,
+	},
+}
+
+// ParseCommandLine reads the flags and the subcommand. Flags are accepted
+// before and after the subcommand. A nil command means the process must exit
+// with the returned code, after a usage error or -h.
+func ParseCommandLine(arguments []string, stderr io.Writer) (*CLICommand, []string, CLIOptions, int) {
+	var options CLIOptions
+
+	flags := flag.NewFlagSet("database_sync", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.BoolVar(&options.DryRun, "dry-run", false, "compute the writes without applying them (also DRY_RUN=true)")
+	flags.StringVar(&options.ConfigFile, "config", "", "YAML config file (also CONFIG_FILE, default config.yaml when present)")
+	flags.Usage = func() { printUsage(stderr, flags) }
+
+	var positional []string
+	for {
+		if err := flags.Parse(arguments); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, nil, options, EXIT_OK
+			}
+			return nil, nil, options, EXIT_USAGE
+		}
+		arguments = flags.Args()
+		if len(arguments) == 0 {
+			break
+		}
+		positional = append(positional, arguments[0])
+		arguments = arguments[1:]
+	}
+
+	if len(positional) == 0 {
+		positional = []string{"daemon"}
+	}
+
+	for _, command := range cliCommands {
+		if command.Name != positional[0] {
+			continue
+		}
+
+		args := positional[1:]
+		if len(args) < command.MinArgs || len(args) > command.MaxArgs {
+			fmt.Fprintf(stderr, "usage: database_sync [flags] %s\n", command.Usage)
+			return nil, nil, options, EXIT_USAGE
+		}
+		if command.TakesEntity && len(args) == 0 {
+			args = []string{ALL_ENTITIES}
+		}
+		return command, args, options, EXIT_OK
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n", positional[0])
+	printUsage(stderr, flags)
+	return nil, nil, options, EXIT_USAGE
+}
+
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: database_sync [flags] [command] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, command := range cliCommands {
+		fmt.Fprintf(w, "  %-24s %s\n", command.Usage, command.Help)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	flags.PrintDefaults()
+	fmt.Fprintf(w, "\nexit codes: %d success, %d a job failed or was blocked, %d usage or configuration error\n", EXIT_OK, EXIT_FAILURE, EXIT_USAGE)
+}
+
+// ValidateEntity checks the entity argument before connecting to anything.
+func (c *CLICommand) ValidateEntity(scheduler *Scheduler, args []string) error {
+	if !c.TakesEntity || args[0] == ALL_ENTITIES || scheduler.HasJob(args[0]) {
+		return nil
+	}
+	return fmt.Errorf("unknown entity %q, expected one of: %s, %s", args[0], strings.Join(scheduler.JobNames(), ", "), ALL_ENTITIES)
+}
+
+func runDaemon(ctx context.Context, scheduler *Scheduler, args []string) int {
+	if scheduler.dryRun {
+		slog.Info("dry run requested, running a single cycle instead of the daemon")
+		return runSync(ctx, scheduler, []string{ALL_ENTITIES})
+	}
+
+	if err := scheduler.LoadSchedules(); err != nil {
+		slog.Error("invalid job schedule", "error", err)
+		return EXIT_USAGE
+	}
+
+	cfg := scheduler.deps.Config
+	mongoDB := scheduler.deps.Connections.MongoDB()
+
+	if err := EnsureSyncRunIndexes(ctx, mongoDB, cfg.Sync.RunsRetention()); err != nil {
+		slog.Warn("failed to prepare sync run history", "error", err)
+	}
+	if cfg.Sync.ChangeLog {
+		if err := EnsureSyncChangeIndexes(ctx, mongoDB, cfg.Sync.RunsRetention()); err != nil {
+			slog.Warn("failed to prepare sync change log", "error", err)
+		}
+	}
+	if cfg.Tiny.WebhookSecret != "" {
+		if err := EnsureWebhookEventIndexes(ctx, mongoDB, cfg.Sync.RunsRetention()); err != nil {
+			slog.Warn("failed to prepare webhook events", "error", err)
+		}
+	}
+	if err := scheduler.RestoreStatus(ctx); err != nil {
+		slog.Warn("failed to restore job status from sync run history", "error", err)
+	}
+
+	server := NewHTTPServer(ctx, scheduler.deps, scheduler)
+	StartHTTPServer("status", server)
+
+	var webhookServer *http.Server
+	if cfg.Tiny.WebhookSecret != "" {
+		webhookServer = NewWebhookServer(scheduler.deps)
+		StartHTTPServer("webhooks", webhookServer)
+	}
+
+	scheduler.Start(ctx)
+	<-ctx.Done()
+
+	timeout := cfg.Sync.ShutdownTimeout
+	slog.Info("shutdown requested, waiting for running synchronizations", "timeout", timeout.String())
+
+	// New runs are already refused, so the HTTP server only goes down once
+	// the runs finished and /status could still be read meanwhile.
+	code := EXIT_OK
+	if !scheduler.Wait(timeout) {
+		slog.Error("synchronizations still running after the shutdown timeout")
+		code = EXIT_FAILURE
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("failed to stop the HTTP server cleanly", "error", err)
+	}
+	if webhookServer != nil {
+		if err := webhookServer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("failed to stop the webhook server cleanly", "error", err)
+		}
+	}
+
+	slog.Info("shutdown complete")
+	return code
+}
+
+// runSync prints the run summaries as JSON on stdout and fails when any job
+// did not succeed, blocked ones included.
+func runSync(ctx context.Context, scheduler *Scheduler, args []string) int {
+	var summaries []RunSummary
+	if args[0] == ALL_ENTITIES {
+		summaries = scheduler.RunCycle(ctx)
+	} else {
+		result, err := scheduler.Trigger(ctx, args[0])
+		if err != nil {
+			slog.Error("failed to start synchronization", "entity", args[0], "error", err)
+			return EXIT_FAILURE
+		}
+		summaries = []RunSummary{<-result}
+	}
+
+	if err := printJSON(map[string]any{"dry_run": scheduler.dryRun, "runs": summaries}); err != nil {
+		return EXIT_FAILURE
+	}
+
+	for _, summary := range summaries {
+		if summary.Outcome != JobSucceeded {
+			return EXIT_FAILURE
+		}
+	}
+	return EXIT_OK
+}
+
+func runDiff(ctx context.Context, scheduler *Scheduler, args []string) int {
+	scheduler.SetDryRun(true)
+	return runSync(ctx, scheduler, args)
+}
+
+func runReconcile(ctx context.Context, scheduler *Scheduler, args []string) int {
+	scheduler.SetForceFullSync(true)
+	return runSync(ctx, scheduler, args)
+}
+
+// runStatus fails when the last run of any job failed, so it can back a
+// monitoring probe.
+func runStatus(ctx context.Context, scheduler *Scheduler, args []string) int {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if err := scheduler.RestoreStatus(ctx); err != nil {
+		slog.Error("failed to load job status", "error", err)
+		return EXIT_FAILURE
+	}
+
+	statuses := scheduler.Status()
+	if err := printJSON(map[string]any{"jobs": statuses}); err != nil {
+		return EXIT_FAILURE
+	}
+
+	for _, status := range statuses {
+		if status.LastOutcome == JobFailed {
+			return EXIT_FAILURE
+		}
+	}
+	return EXIT_OK
+}
+
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		slog.Error("failed to write output", "error", err)
+		return err
+	}
+	return nil
+}
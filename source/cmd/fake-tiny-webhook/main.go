@@ -0,0 +1,99 @@
+// Command fake-tiny-webhook posts notifications shaped like Tiny's to a
+// running synchronizer, to try POST /webhooks/tiny without a Tiny account:
+//
+//	go run ./cmd/fake-tiny-webhook -order 123456 -situacao Entregue
+//	go run ./cmd/fake-tiny-webhook -order 123456 -code AA123456789BR -service "Correios SEDEX" -repeat 2
+//
+// The secret defaults to TINY_WEBHOOK_SECRET. With -repeat, every
+// notification after the first one should come back as a duplicate.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	endpoint := flag.String("url", "http://localhost:8081/webhooks/tiny", "webhook endpoint of the synchronizer")
+	secret := flag.String("secret", os.Getenv("TINY_WEBHOOK_SECRET"), "webhook secret (default TINY_WEBHOOK_SECRET)")
+	order := flag.String("order", "", "Tiny order id (required)")
+	situacao := flag.String("situacao", "", "situação of the order, such as Faturado or Entregue")
+	code := flag.String("code", "", "tracking code, sends a tracking notification")
+	trackingURL := flag.String("tracking-url", "", "tracking URL")
+	service := flag.String("service", "", "shipping service, such as Correios SEDEX")
+	repeat := flag.Int("repeat", 1, "how many times to post the notification")
+	flag.Parse()
+
+	if *order == "" || (*situacao == "" && *code == "") {
+		fmt.Fprintln(os.Stderr, "usage: fake-tiny-webhook -order <id> (-situacao <situação> | -code <tracking code>) [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	notification := map[string]any{"versao": "1.0.0", "cnpj": "00000000000000"}
+	if *code != "" {
+		notification["tipo"] = "rastreio"
+		notification["dados"] = map[string]string{
+			"idPedido":       *order,
+			"codigoRastreio": *code,
+			"urlRastreio":    *trackingURL,
+			"formaFrete":     *service,
+		}
+	} else {
+		notification["tipo"] = "situacao_pedido"
+		notification["dados"] = map[string]string{
+			"id":                *order,
+			"codigoSituacao":    situacaoCode(*situacao),
+			"descricaoSituacao": *situacao,
+		}
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	target, err := url.Parse(*endpoint)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -url:", err)
+		os.Exit(2)
+	}
+	query := target.Query()
+	query.Set("secret", *secret)
+	target.RawQuery = query.Encode()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	failed := false
+	for i := 0; i < *repeat; i++ {
+		resp, err := client.Post(target.String(), "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		answer, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		fmt.Printf("%d %s", resp.StatusCode, answer)
+		if resp.StatusCode != http.StatusOK {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// situacaoCode turns "Pronto para envio" into "pronto_para_envio", the form
+// of codigoSituacao.
+func situacaoCode(situacao string) string {
+	return strings.ToLower(strings.ReplaceAll(situacao, " ", "_"))
+}
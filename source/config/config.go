@@ -0,0 +1,547 @@
+package config
+
+import (
+	"database_sync/database"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ENV_DEVELOPMENT = "development"
+	ENV_HOMOLOG     = "homolog"
+	ENV_RELEASE     = "production"
+
+	DELETE_POLICY_HARD = "hard"
+	DELETE_POLICY_SOFT = "soft"
+	DELETE_POLICY_OFF  = "off"
+
+	LOG_FORMAT_JSON = "json"
+	LOG_FORMAT_TEXT = "text"
+
+	DEFAULT_HTTP_PORT                = "8080"
+	DEFAULT_LOG_LEVEL                = "info"
+	DEFAULT_TINY_REQUESTS_PER_MINUTE = 30
+	DEFAULT_TINY_WORKERS             = 3
+	DEFAULT_TINY_TIMEOUT             = 30 * time.Second
+	DEFAULT_TINY_MAX_RETRIES         = 3
+	DEFAULT_JOB_TIMEOUT              = 20 * time.Minute
+	DEFAULT_WRITE_TIMEOUT            = 2 * time.Minute
+	DEFAULT_FULL_RECONCILIATION      = 1 * time.Hour
+	DEFAULT_SYNC_RUNS_RETENTION_DAYS = 30
+	DEFAULT_DELETE_MAX_PERCENT       = 20.0
+	DEFAULT_TRACKING_LOOKBACK        = 60 * 24 * time.Hour
+	DEFAULT_TRACKING_BACKOFF         = 15 * time.Minute
+	DEFAULT_TRACKING_BACKOFF_MAX     = 12 * time.Hour
+	DEFAULT_TRACKING_MAX_ATTEMPTS    = 40
+	DEFAULT_CARRIERS_TIMEOUT         = 30 * time.Second
+	DEFAULT_CARRIERS_WORKERS         = 4
+	DEFAULT_CARRIERS_LOOKBACK        = 60 * 24 * time.Hour
+	DEFAULT_CARRIERS_BACKOFF         = 1 * time.Hour
+	DEFAULT_CARRIERS_BACKOFF_MAX     = 24 * time.Hour
+	DEFAULT_CARRIERS_MAX_ATTEMPTS    = 30
+
+	// MIN_WEBHOOK_SECRET_LENGTH keeps the webhook secret from being guessed.
+	MIN_WEBHOOK_SECRET_LENGTH = 16
+	DEFAULT_TINY_WEBHOOK_ADDR = ":8081"
+
+	// DEFAULT_SCHEDULE keeps the original behavior of running every job
+	// every 30 seconds when nothing is configured.
+	DEFAULT_SCHEDULE = "30s"
+
+	// DEFAULT_SHUTDOWN_TIMEOUT stays below the 30 seconds Kubernetes grants
+	// by default between SIGTERM and SIGKILL.
+	DEFAULT_SHUTDOWN_TIMEOUT = 25 * time.Second
+)
+
+var allowedEnvValues = []string{ENV_DEVELOPMENT, ENV_HOMOLOG, ENV_RELEASE}
+
+var allowedDeletePolicies = []string{DELETE_POLICY_HARD, DELETE_POLICY_SOFT, DELETE_POLICY_OFF}
+
+// deleteEntities are the jobs that reconcile deletions and so accept a delete
+// policy of their own.
+var deleteEntities = []string{"users", "products", "leads", "budgets", "orders"}
+
+// invalidDatabaseChars are the characters MongoDB refuses in a database name.
+const invalidDatabaseChars = "/\\. \"$"
+
+var allowedLogFormats = []string{LOG_FORMAT_JSON, LOG_FORMAT_TEXT}
+
+var allowedLogLevels = []string{"debug", "info", "warn", "error"}
+
+// Config is the whole configuration of the synchronizer. Load builds it from
+// the defaults, then the config file, then .env, then the process
+// environment, each layer overriding the previous one.
+type Config struct {
+	Env        string `yaml:"env"`
+	MongoDBURI string `yaml:"mongodb_uri"`
+	MySQLURI   string `yaml:"mysql_uri"`
+
+	Mongo     MongoConfig     `yaml:"mongo"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Log       LogConfig       `yaml:"log"`
+	Tiny      TinyConfig      `yaml:"tiny"`
+	Sync      SyncConfig      `yaml:"sync"`
+	Delete    DeleteConfig    `yaml:"delete"`
+	Schedules SchedulesConfig `yaml:"schedules"`
+	Tracking  TrackingConfig  `yaml:"tracking"`
+	Carriers  CarriersConfig  `yaml:"carriers"`
+
+	redactor *strings.Replacer
+	warnings []string
+}
+
+// MongoConfig names where the jobs write. Database defaults to the value of
+// Env, and Collections maps logical collection names, such as orders or
+// sync_runs, to the collection to use instead.
+type MongoConfig struct {
+	Database    string            `yaml:"database"`
+	Collections map[string]string `yaml:"collections"`
+}
+
+type HTTPConfig struct {
+	Port string `yaml:"port"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// TinyConfig is the access to the Tiny ERP API. RequestsPerMinute is the
+// quota of the Tiny plan, 30 on the smallest one with API access, and Workers
+// is how many lookups may be in flight within it.
+type TinyConfig struct {
+	Token             string        `yaml:"token"`
+	BaseURL           string        `yaml:"base_url"`
+	RequestsPerMinute int           `yaml:"requests_per_minute"`
+	Workers           int           `yaml:"workers"`
+	Timeout           time.Duration `yaml:"timeout"`
+	MaxRetries        int           `yaml:"max_retries"`
+	// WebhookSecret turns on POST /webhooks/tiny, which only accepts
+	// notifications carrying it. It is served on WebhookAddr, apart from the
+	// status server, so only the webhook has to be reachable by Tiny.
+	WebhookSecret string `yaml:"webhook_secret"`
+	WebhookAddr   string `yaml:"webhook_addr"`
+}
+
+// SyncConfig drives the sync jobs. JobTimeout bounds a whole run and
+// WriteTimeout a single bulk write, which a shutdown lets finish. A zero
+// BatchSize sizes the batches from the number of writes.
+type SyncConfig struct {
+	JobTimeout        time.Duration `yaml:"job_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	BatchSize         int           `yaml:"batch_size"`
+	DryRun            bool          `yaml:"dry_run"`
+	ChangeLog         bool          `yaml:"change_log"`
+	RunsRetentionDays int           `yaml:"runs_retention_days"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	// FullReconciliationInterval is how often a job ignores its watermark
+	// and reads the whole table, which is when deleted rows are noticed.
+	FullReconciliationInterval time.Duration `yaml:"full_reconciliation_interval"`
+}
+
+// DeleteConfig says what happens to documents whose MySQL row is gone.
+// Entities overrides Policy for a single job.
+type DeleteConfig struct {
+	Policy     string            `yaml:"policy"`
+	MaxPercent float64           `yaml:"max_percent"`
+	Entities   map[string]string `yaml:"entities"`
+}
+
+// TrackingConfig bounds how the tracking job polls Tiny. Orders older than
+// Lookback are left alone.
+type TrackingConfig struct {
+	Lookback      time.Duration `yaml:"lookback"`
+	BackoffConfig `yaml:",inline"`
+}
+
+// BackoffConfig spaces the checks of an order. An order is read again after
+// Backoff, doubled up to BackoffMax after every check that brought nothing
+// new, and no longer read after MaxAttempts such checks in a row.
+type BackoffConfig struct {
+	Backoff     time.Duration `yaml:"backoff"`
+	BackoffMax  time.Duration `yaml:"backoff_max"`
+	MaxAttempts int           `yaml:"max_attempts"`
+}
+
+// NextCheck returns the delay before an order is read again, after attempts
+// checks in a row brought nothing new.
+func (c BackoffConfig) NextCheck(attempts int) time.Duration {
+	delay := c.Backoff
+	for range attempts {
+		if delay >= c.BackoffMax {
+			break
+		}
+		delay *= 2
+	}
+	return min(delay, c.BackoffMax)
+}
+
+// CarriersConfig is the access to the carriers tracked directly, once Tiny
+// gave an order its tracking code. A carrier without credentials is skipped.
+// AutoDeliver moves an order to Entregue when its carrier, or a Tiny webhook,
+// reports delivery.
+// Lookback bounds how old an undelivered order may be and still be tracked,
+// and the backoff spaces the lookups of a parcel with no new events.
+type CarriersConfig struct {
+	Timeout       time.Duration `yaml:"timeout"`
+	Workers       int           `yaml:"workers"`
+	Lookback      time.Duration `yaml:"lookback"`
+	BackoffConfig `yaml:",inline"`
+	AutoDeliver   bool           `yaml:"auto_deliver"`
+	Correios      CorreiosConfig `yaml:"correios"`
+	Jadlog        JadlogConfig   `yaml:"jadlog"`
+	Loggi         LoggiConfig    `yaml:"loggi"`
+}
+
+// CorreiosConfig holds the credentials of the Correios API portal. The access
+// code is generated there, and the posting card is the one of the contract.
+type CorreiosConfig struct {
+	User        string `yaml:"user"`
+	AccessCode  string `yaml:"access_code"`
+	PostingCard string `yaml:"posting_card"`
+	BaseURL     string `yaml:"base_url"`
+}
+
+func (c CorreiosConfig) Enabled() bool {
+	return c.User != "" || c.AccessCode != "" || c.PostingCard != ""
+}
+
+type JadlogConfig struct {
+	Token   string `yaml:"token"`
+	BaseURL string `yaml:"base_url"`
+}
+
+func (c JadlogConfig) Enabled() bool {
+	return c.Token != ""
+}
+
+type LoggiConfig struct {
+	Token     string `yaml:"token"`
+	CompanyID string `yaml:"company_id"`
+	BaseURL   string `yaml:"base_url"`
+}
+
+func (c LoggiConfig) Enabled() bool {
+	return c.Token != "" || c.CompanyID != ""
+}
+
+// SchedulesConfig holds the default schedule and the per-job overrides. A
+// field left out of a job entry is taken from Default.
+type SchedulesConfig struct {
+	Default ScheduleConfig            `yaml:"default"`
+	Jobs    map[string]ScheduleConfig `yaml:"jobs"`
+}
+
+type ScheduleConfig struct {
+	Spec    string         `yaml:"spec"`
+	Jitter  *time.Duration `yaml:"jitter"`
+	Enabled *bool          `yaml:"enabled"`
+}
+
+// ScheduleSettings is the schedule of one job once the defaults are applied.
+type ScheduleSettings struct {
+	Spec    string
+	Jitter  time.Duration
+	Enabled bool
+}
+
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{Port: DEFAULT_HTTP_PORT},
+		Log:  LogConfig{Format: LOG_FORMAT_JSON, Level: DEFAULT_LOG_LEVEL},
+		Tiny: TinyConfig{
+			RequestsPerMinute: DEFAULT_TINY_REQUESTS_PER_MINUTE,
+			Workers:           DEFAULT_TINY_WORKERS,
+			Timeout:           DEFAULT_TINY_TIMEOUT,
+			MaxRetries:        DEFAULT_TINY_MAX_RETRIES,
+			WebhookAddr:       DEFAULT_TINY_WEBHOOK_ADDR,
+		},
+		Sync: SyncConfig{
+			JobTimeout:                 DEFAULT_JOB_TIMEOUT,
+			WriteTimeout:               DEFAULT_WRITE_TIMEOUT,
+			RunsRetentionDays:          DEFAULT_SYNC_RUNS_RETENTION_DAYS,
+			ShutdownTimeout:            DEFAULT_SHUTDOWN_TIMEOUT,
+			FullReconciliationInterval: DEFAULT_FULL_RECONCILIATION,
+		},
+		Delete: DeleteConfig{
+			Policy:     DELETE_POLICY_HARD,
+			MaxPercent: DEFAULT_DELETE_MAX_PERCENT,
+		},
+		Schedules: SchedulesConfig{
+			Default: ScheduleConfig{Spec: DEFAULT_SCHEDULE},
+		},
+		Tracking: TrackingConfig{
+			Lookback: DEFAULT_TRACKING_LOOKBACK,
+			BackoffConfig: BackoffConfig{
+				Backoff:     DEFAULT_TRACKING_BACKOFF,
+				BackoffMax:  DEFAULT_TRACKING_BACKOFF_MAX,
+				MaxAttempts: DEFAULT_TRACKING_MAX_ATTEMPTS,
+			},
+		},
+		Carriers: CarriersConfig{
+			Timeout:  DEFAULT_CARRIERS_TIMEOUT,
+			Workers:  DEFAULT_CARRIERS_WORKERS,
+			Lookback: DEFAULT_CARRIERS_LOOKBACK,
+			BackoffConfig: BackoffConfig{
+				Backoff:     DEFAULT_CARRIERS_BACKOFF,
+				BackoffMax:  DEFAULT_CARRIERS_BACKOFF_MAX,
+				MaxAttempts: DEFAULT_CARRIERS_MAX_ATTEMPTS,
+			},
+		},
+	}
+}
+
+// Validate reports every invalid setting at once, each prefixed with its
+// config file path and its environment variable.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Env == "" {
+		invalid("env (%s) is required", ENV)
+	} else if !slices.Contains(allowedEnvValues, c.Env) {
+		invalid("env (%s): %q is not one of %s", ENV, c.Env, strings.Join(allowedEnvValues, ", "))
+	}
+	if c.MongoDBURI == "" {
+		invalid("mongodb_uri (%s) is required", MONGODB_URI)
+	}
+	if c.MySQLURI == "" {
+		invalid("mysql_uri (%s) is required", MYSQL_URI)
+	}
+
+	if strings.ContainsAny(c.Mongo.Database, invalidDatabaseChars) {
+		invalid("mongo.database (%s): %q must not contain spaces or any of / \\ . \" $", MONGODB_DATABASE, c.Mongo.Database)
+	}
+	usedBy := make(map[string]string)
+	for _, logical := range database.Collections {
+		name := c.Mongo.Collections[logical]
+		if name == "" {
+			name = logical
+		}
+		if other, ok := usedBy[name]; ok {
+			invalid("mongo.collections: %s and %s both use collection %q", other, logical, name)
+		}
+		usedBy[name] = logical
+	}
+	for logical, name := range c.Mongo.Collections {
+		key := MONGODB_COLLECTION + "_" + strings.ToUpper(logical)
+		if !slices.Contains(database.Collections, logical) {
+			invalid("mongo.collections.%s (%s): unknown collection, expected one of %s", logical, key, strings.Join(database.Collections, ", "))
+		} else if name == "" || strings.Contains(name, "$") || strings.HasPrefix(name, "system.") {
+			invalid("mongo.collections.%s (%s): %q is not a valid collection name", logical, key, name)
+		}
+	}
+
+	if port, err := strconv.Atoi(c.HTTP.Port); err != nil || port <= 0 || port > 65535 {
+		invalid("http.port (%s): %q is not a valid port", PORT, c.HTTP.Port)
+	}
+
+	if !slices.Contains(allowedLogFormats, c.Log.Format) {
+		invalid("log.format (%s): %q is not one of %s", LOG_FORMAT, c.Log.Format, strings.Join(allowedLogFormats, ", "))
+	}
+	if !slices.Contains(allowedLogLevels, strings.ToLower(c.Log.Level)) {
+		invalid("log.level (%s): %q is not one of %s", LOG_LEVEL, c.Log.Level, strings.Join(allowedLogLevels, ", "))
+	}
+
+	if c.Tiny.Token == "" {
+		invalid("tiny.token (%s) is required", TINY_TOKEN)
+	}
+	if c.Tiny.BaseURL != "" && !isHTTPURL(c.Tiny.BaseURL) {
+		invalid("tiny.base_url (%s): %q is not an http(s) URL", TINY_BASE_URL, c.Tiny.BaseURL)
+	}
+	if c.Tiny.RequestsPerMinute <= 0 {
+		invalid("tiny.requests_per_minute (%s) must be positive", TINY_REQUESTS_PER_MINUTE)
+	}
+	if c.Tiny.Workers <= 0 {
+		invalid("tiny.workers (%s) must be positive", TINY_WORKERS)
+	}
+	if c.Tiny.Timeout <= 0 {
+		invalid("tiny.timeout (%s) must be positive", TINY_TIMEOUT)
+	}
+	if c.Tiny.MaxRetries < 0 {
+		invalid("tiny.max_retries (%s) must not be negative", TINY_MAX_RETRIES)
+	}
+	if secret := c.Tiny.WebhookSecret; secret != "" && len(secret) < MIN_WEBHOOK_SECRET_LENGTH {
+		invalid("tiny.webhook_secret (%s) must have at least %d characters", TINY_WEBHOOK_SECRET, MIN_WEBHOOK_SECRET_LENGTH)
+	}
+	if c.Tiny.WebhookSecret != "" {
+		if _, port, err := net.SplitHostPort(c.Tiny.WebhookAddr); err != nil {
+			invalid("tiny.webhook_addr (%s): %q is not a host:port address", TINY_WEBHOOK_ADDR, c.Tiny.WebhookAddr)
+		} else if port == c.HTTP.Port {
+			invalid("tiny.webhook_addr (%s) must not use the port of the status server (%s)", TINY_WEBHOOK_ADDR, PORT)
+		}
+	}
+
+	if c.Sync.JobTimeout <= 0 {
+		invalid("sync.job_timeout (%s) must be positive", SYNC_JOB_TIMEOUT)
+	}
+	if c.Sync.WriteTimeout <= 0 {
+		invalid("sync.write_timeout (%s) must be positive", SYNC_WRITE_TIMEOUT)
+	}
+	if c.Sync.BatchSize < 0 {
+		invalid("sync.batch_size (%s) must not be negative", SYNC_BATCH_SIZE)
+	}
+	if c.Sync.RunsRetentionDays <= 0 {
+		invalid("sync.runs_retention_days (%s) must be a positive number of days", SYNC_RUNS_RETENTION_DAYS)
+	}
+	if c.Sync.ShutdownTimeout <= 0 {
+		invalid("sync.shutdown_timeout (%s) must be positive", SHUTDOWN_TIMEOUT)
+	}
+	if c.Sync.FullReconciliationInterval <= 0 {
+		invalid("sync.full_reconciliation_interval (%s) must be positive", SYNC_FULL_RECONCILIATION_INTERVAL)
+	}
+
+	if !slices.Contains(allowedDeletePolicies, c.Delete.Policy) {
+		invalid("delete.policy (%s): %q is not one of %s", DELETE_POLICY, c.Delete.Policy, strings.Join(allowedDeletePolicies, ", "))
+	}
+	for entity, policy := range c.Delete.Entities {
+		key := DELETE_POLICY + "_" + strings.ToUpper(entity)
+		if !slices.Contains(deleteEntities, entity) {
+			invalid("delete.entities.%s (%s): unknown entity, expected one of %s", entity, key, strings.Join(deleteEntities, ", "))
+		} else if !slices.Contains(allowedDeletePolicies, policy) {
+			invalid("delete.entities.%s (%s): %q is not one of %s", entity, key, policy, strings.Join(allowedDeletePolicies, ", "))
+		}
+	}
+	if c.Delete.MaxPercent < 0 || c.Delete.MaxPercent > 100 {
+		invalid("delete.max_percent (%s) must be between 0 and 100", DELETE_MAX_PERCENT)
+	}
+
+	if jitter := c.Schedules.Default.Jitter; jitter != nil && *jitter < 0 {
+		invalid("schedules.default.jitter (%s) must not be negative", SCHEDULE_JITTER)
+	}
+	for job, schedule := range c.Schedules.Jobs {
+		if schedule.Jitter != nil && *schedule.Jitter < 0 {
+			invalid("schedules.jobs.%s.jitter (%s_%s) must not be negative", job, SCHEDULE_JITTER, strings.ToUpper(job))
+		}
+	}
+
+	if c.Tracking.Lookback <= 0 {
+		invalid("tracking.lookback (%s) must be positive", TRACKING_LOOKBACK)
+	}
+	if c.Tracking.Backoff <= 0 {
+		invalid("tracking.backoff (%s) must be positive", TRACKING_BACKOFF)
+	}
+	if c.Tracking.BackoffMax < c.Tracking.Backoff {
+		invalid("tracking.backoff_max (%s) must not be below tracking.backoff", TRACKING_BACKOFF_MAX)
+	}
+	if c.Tracking.MaxAttempts <= 0 {
+		invalid("tracking.max_attempts (%s) must be positive", TRACKING_MAX_ATTEMPTS)
+	}
+
+	if c.Carriers.Timeout <= 0 {
+		invalid("carriers.timeout (%s) must be positive", CARRIERS_TIMEOUT)
+	}
+	if c.Carriers.Workers <= 0 {
+		invalid("carriers.workers (%s) must be positive", CARRIERS_WORKERS)
+	}
+	if c.Carriers.Lookback <= 0 {
+		invalid("carriers.lookback (%s) must be positive", CARRIERS_LOOKBACK)
+	}
+	if c.Carriers.Backoff <= 0 {
+		invalid("carriers.backoff (%s) must be positive", CARRIERS_BACKOFF)
+	}
+	if c.Carriers.BackoffMax < c.Carriers.Backoff {
+		invalid("carriers.backoff_max (%s) must not be below carriers.backoff", CARRIERS_BACKOFF_MAX)
+	}
+	if c.Carriers.MaxAttempts <= 0 {
+		invalid("carriers.max_attempts (%s) must be positive", CARRIERS_MAX_ATTEMPTS)
+	}
+	if correios := c.Carriers.Correios; correios.Enabled() {
+		if correios.User == "" || correios.AccessCode == "" || correios.PostingCard == "" {
+			invalid("carriers.correios (%s, %s, %s): user, access code and posting card are all required", CORREIOS_USER, CORREIOS_ACCESS_CODE, CORREIOS_POSTING_CARD)
+		}
+		if correios.BaseURL != "" && !isHTTPURL(correios.BaseURL) {
+			invalid("carriers.correios.base_url (%s): %q is not an http(s) URL", CORREIOS_BASE_URL, correios.BaseURL)
+		}
+	}
+	if jadlog := c.Carriers.Jadlog; jadlog.BaseURL != "" && !isHTTPURL(jadlog.BaseURL) {
+		invalid("carriers.jadlog.base_url (%s): %q is not an http(s) URL", JADLOG_BASE_URL, jadlog.BaseURL)
+	}
+	if loggi := c.Carriers.Loggi; loggi.Enabled() {
+		if loggi.Token == "" || loggi.CompanyID == "" {
+			invalid("carriers.loggi (%s, %s): token and company id are both required", LOGGI_TOKEN, LOGGI_COMPANY_ID)
+		}
+		if loggi.BaseURL != "" && !isHTTPURL(loggi.BaseURL) {
+			invalid("carriers.loggi.base_url (%s): %q is not an http(s) URL", LOGGI_BASE_URL, loggi.BaseURL)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(value string) bool {
+	uri, err := url.Parse(value)
+	return err == nil && (uri.Scheme == "http" || uri.Scheme == "https") && uri.Host != ""
+}
+
+// MongoDatabase is the database the jobs write to.
+func (c *Config) MongoDatabase() string {
+	if c.Mongo.Database != "" {
+		return c.Mongo.Database
+	}
+	return c.Env
+}
+
+// BatchSizeFor returns how many writes go in one bulk write when total writes
+// are pending.
+func (c SyncConfig) BatchSizeFor(total int) int {
+	if c.BatchSize > 0 {
+		return c.BatchSize
+	}
+	switch {
+	case total > 5000:
+		return 500
+	case total > 1000:
+		return 200
+	default:
+		return 50
+	}
+}
+
+func (c SyncConfig) RunsRetention() time.Duration {
+	return time.Duration(c.RunsRetentionDays) * 24 * time.Hour
+}
+
+// PolicyFor returns the delete policy of entity, falling back to Policy.
+func (c DeleteConfig) PolicyFor(entity string) string {
+	if policy, ok := c.Entities[entity]; ok {
+		return policy
+	}
+	return c.Policy
+}
+
+// For returns the schedule of job, taking every field the job does not set
+// from Default. Jobs are enabled unless configured otherwise.
+func (c SchedulesConfig) For(job string) ScheduleSettings {
+	settings := ScheduleSettings{Spec: c.Default.Spec, Enabled: true}
+	if c.Default.Jitter != nil {
+		settings.Jitter = *c.Default.Jitter
+	}
+	if c.Default.Enabled != nil {
+		settings.Enabled = *c.Default.Enabled
+	}
+
+	override, ok := c.Jobs[job]
+	if !ok {
+		return settings
+	}
+	if override.Spec != "" {
+		settings.Spec = override.Spec
+	}
+	if override.Jitter != nil {
+		settings.Jitter = *override.Jitter
+	}
+	if override.Enabled != nil {
+		settings.Enabled = *override.Enabled
+	}
+	return settings
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Env = ENV_HOMOLOG
+	cfg.MongoDBURI = "mongodb://localhost"
+	cfg.MySQLURI = "user:pass@tcp(localhost)/app"
+	cfg.Tiny.Token = "token"
+	return cfg
+}
+
+func TestValidateWebhookAddr(t *testing.T) {
+	tests := []struct {
+		name, secret, addr, want string
+	}{
+		{name: "webhook off", addr: ""},
+		{name: "default address", secret: "0123456789abcdef", addr: DEFAULT_TINY_WEBHOOK_ADDR},
+		{name: "port only", secret: "0123456789abcdef", addr: "9090", want: TINY_WEBHOOK_ADDR},
+		{name: "status server port", secret: "0123456789abcdef", addr: "0.0.0.0:" + DEFAULT_HTTP_PORT, want: "port of the status server"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Tiny.WebhookSecret = test.secret
+			cfg.Tiny.WebhookAddr = test.addr
+
+			err := cfg.Validate()
+			if test.want == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("error = %v, want one about %s", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package config
+
+import (
+	"bufio"
+	"database_sync/database"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables, also accepted in .env. Each one overrides the
+// setting named next to it in Validate's messages.
+const (
+	CONFIG_FILE = "CONFIG_FILE"
+
+	ENV         = "ENV"
+	MONGODB_URI = "MONGODB_URI"
+	MYSQL_URI   = "MYSQL_URI"
+
+	// MONGODB_COLLECTION takes the logical collection as a suffix, e.g.
+	// MONGODB_COLLECTION_ORDERS=orders_rehearsal.
+	MONGODB_DATABASE   = "MONGODB_DATABASE"
+	MONGODB_COLLECTION = "MONGODB_COLLECTION"
+
+	PORT = "PORT"
+
+	LOG_FORMAT = "LOG_FORMAT"
+	LOG_LEVEL  = "LOG_LEVEL"
+
+	TINY_TOKEN               = "TINY_TOKEN"
+	TINY_BASE_URL            = "TINY_BASE_URL"
+	TINY_REQUESTS_PER_MINUTE = "TINY_REQUESTS_PER_MINUTE"
+	TINY_WORKERS             = "TINY_WORKERS"
+	TINY_TIMEOUT             = "TINY_TIMEOUT"
+	TINY_MAX_RETRIES         = "TINY_MAX_RETRIES"
+	TINY_WEBHOOK_SECRET      = "TINY_WEBHOOK_SECRET"
+	TINY_WEBHOOK_ADDR        = "TINY_WEBHOOK_ADDR"
+
+	SYNC_JOB_TIMEOUT                  = "SYNC_JOB_TIMEOUT"
+	SYNC_WRITE_TIMEOUT                = "SYNC_WRITE_TIMEOUT"
+	SYNC_BATCH_SIZE                   = "SYNC_BATCH_SIZE"
+	SYNC_RUNS_RETENTION_DAYS          = "SYNC_RUNS_RETENTION_DAYS"
+	SYNC_CHANGES                      = "SYNC_CHANGES"
+	SYNC_FULL_RECONCILIATION_INTERVAL = "SYNC_FULL_RECONCILIATION_INTERVAL"
+	DRY_RUN                           = "DRY_RUN"
+	SHUTDOWN_TIMEOUT                  = "SHUTDOWN_TIMEOUT"
+
+	// DELETE_POLICY also accepts a _<ENTITY> suffix, e.g.
+	// DELETE_POLICY_LEADS=soft.
+	DELETE_POLICY      = "DELETE_POLICY"
+	DELETE_MAX_PERCENT = "DELETE_MAX_PERCENT"
+
+	// SCHEDULE, SCHEDULE_JITTER and SCHEDULE_ENABLED also accept a _<JOB>
+	// suffix, e.g. SCHEDULE_TRACKING=1h.
+	SCHEDULE         = "SCHEDULE"
+	SCHEDULE_JITTER  = "SCHEDULE_JITTER"
+	SCHEDULE_ENABLED = "SCHEDULE_ENABLED"
+
+	TRACKING_LOOKBACK     = "TRACKING_LOOKBACK"
+	TRACKING_BACKOFF      = "TRACKING_BACKOFF"
+	TRACKING_BACKOFF_MAX  = "TRACKING_BACKOFF_MAX"
+	TRACKING_MAX_ATTEMPTS = "TRACKING_MAX_ATTEMPTS"
+
+	CARRIERS_TIMEOUT      = "CARRIERS_TIMEOUT"
+	CARRIERS_WORKERS      = "CARRIERS_WORKERS"
+	CARRIERS_LOOKBACK     = "CARRIERS_LOOKBACK"
+	CARRIERS_BACKOFF      = "CARRIERS_BACKOFF"
+	CARRIERS_BACKOFF_MAX  = "CARRIERS_BACKOFF_MAX"
+	CARRIERS_MAX_ATTEMPTS = "CARRIERS_MAX_ATTEMPTS"
+	CARRIERS_AUTO_DELIVER = "CARRIERS_AUTO_DELIVER"
+	CORREIOS_USER         = "CORREIOS_USER"
+	CORREIOS_ACCESS_CODE  = "CORREIOS_ACCESS_CODE"
+	CORREIOS_POSTING_CARD = "CORREIOS_POSTING_CARD"
+	CORREIOS_BASE_URL     = "CORREIOS_BASE_URL"
+	JADLOG_TOKEN          = "JADLOG_TOKEN"
+	JADLOG_BASE_URL       = "JADLOG_BASE_URL"
+	LOGGI_TOKEN           = "LOGGI_TOKEN"
+	LOGGI_COMPANY_ID      = "LOGGI_COMPANY_ID"
+	LOGGI_BASE_URL        = "LOGGI_BASE_URL"
+)
+
+const (
+	DEFAULT_CONFIG_FILE = "config.yaml"
+	DOTENV_FILE         = ".env"
+)
+
+// Load reads the configuration. path is the config file given on the command
+// line; when empty, CONFIG_FILE is used, and then config.yaml if it exists in
+// the working directory. The .env file is optional too. Secrets may be given
+// as files with the _FILE variables. Every error found is returned together,
+// after the layers are merged. jobs are the names of the scheduled jobs, the
+// suffixes SCHEDULE, SCHEDULE_JITTER and SCHEDULE_ENABLED accept.
+func Load(path string, jobs []string) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv(CONFIG_FILE)
+	}
+	required := path != ""
+	if !required {
+		path = DEFAULT_CONFIG_FILE
+	}
+	if err := cfg.loadFile(path, required); err != nil {
+		return nil, err
+	}
+
+	values, err := readDotEnv(DOTENV_FILE)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range os.Environ() {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			values[key] = value
+		}
+	}
+
+	errs := readSecretFiles(values)
+	errs = append(errs, cfg.applyEnv(values, jobs)...)
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	cfg.redactor = cfg.newRedactor()
+	return cfg, nil
+}
+
+// loadFile merges the YAML file at path over cfg. Unknown keys are rejected,
+// so a typo does not silently fall back to a default.
+func (c *Config) loadFile(path string, required bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readDotEnv returns the KEY=VALUE pairs of the .env file, or nothing when
+// there is no such file. Blank lines and # comments are skipped and values
+// may be quoted.
+func readDotEnv(path string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %d in %s: expected KEY=VALUE", lineNum, path)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// applyEnv overrides cfg with the variables set in values. Empty variables are
+// treated as unset, which is what an unset ${VAR} in a compose file gives.
+func (c *Config) applyEnv(values map[string]string, jobs []string) []error {
+	env := envValues{values: values}
+
+	env.string(ENV, &c.Env)
+	env.string(MONGODB_URI, &c.MongoDBURI)
+	env.string(MYSQL_URI, &c.MySQLURI)
+	env.string(MONGODB_DATABASE, &c.Mongo.Database)
+	env.string(PORT, &c.HTTP.Port)
+	env.string(LOG_FORMAT, &c.Log.Format)
+	env.string(LOG_LEVEL, &c.Log.Level)
+
+	env.string(TINY_TOKEN, &c.Tiny.Token)
+	env.string(TINY_BASE_URL, &c.Tiny.BaseURL)
+	env.int(TINY_REQUESTS_PER_MINUTE, &c.Tiny.RequestsPerMinute)
+	env.int(TINY_WORKERS, &c.Tiny.Workers)
+	env.duration(TINY_TIMEOUT, &c.Tiny.Timeout)
+	env.int(TINY_MAX_RETRIES, &c.Tiny.MaxRetries)
+	env.string(TINY_WEBHOOK_SECRET, &c.Tiny.WebhookSecret)
+	env.string(TINY_WEBHOOK_ADDR, &c.Tiny.WebhookAddr)
+
+	env.duration(SYNC_JOB_TIMEOUT, &c.Sync.JobTimeout)
+	env.duration(SYNC_WRITE_TIMEOUT, &c.Sync.WriteTimeout)
+	env.int(SYNC_BATCH_SIZE, &c.Sync.BatchSize)
+	env.int(SYNC_RUNS_RETENTION_DAYS, &c.Sync.RunsRetentionDays)
+	env.bool(SYNC_CHANGES, &c.Sync.ChangeLog)
+	env.duration(SYNC_FULL_RECONCILIATION_INTERVAL, &c.Sync.FullReconciliationInterval)
+	env.bool(DRY_RUN, &c.Sync.DryRun)
+	env.duration(SHUTDOWN_TIMEOUT, &c.Sync.ShutdownTimeout)
+
+	env.string(DELETE_POLICY, &c.Delete.Policy)
+	env.float(DELETE_MAX_PERCENT, &c.Delete.MaxPercent)
+
+	env.optionalDuration(SCHEDULE_JITTER, &c.Schedules.Default.Jitter)
+	env.optionalBool(SCHEDULE_ENABLED, &c.Schedules.Default.Enabled)
+	env.string(SCHEDULE, &c.Schedules.Default.Spec)
+
+	env.duration(TRACKING_LOOKBACK, &c.Tracking.Lookback)
+	env.duration(TRACKING_BACKOFF, &c.Tracking.Backoff)
+	env.duration(TRACKING_BACKOFF_MAX, &c.Tracking.BackoffMax)
+	env.int(TRACKING_MAX_ATTEMPTS, &c.Tracking.MaxAttempts)
+
+	env.duration(CARRIERS_TIMEOUT, &c.Carriers.Timeout)
+	env.int(CARRIERS_WORKERS, &c.Carriers.Workers)
+	env.duration(CARRIERS_LOOKBACK, &c.Carriers.Lookback)
+	env.duration(CARRIERS_BACKOFF, &c.Carriers.Backoff)
+	env.duration(CARRIERS_BACKOFF_MAX, &c.Carriers.BackoffMax)
+	env.int(CARRIERS_MAX_ATTEMPTS, &c.Carriers.MaxAttempts)
+	env.bool(CARRIERS_AUTO_DELIVER, &c.Carriers.AutoDeliver)
+	env.string(CORREIOS_USER, &c.Carriers.Correios.User)
+	env.string(CORREIOS_ACCESS_CODE, &c.Carriers.Correios.AccessCode)
+	env.string(CORREIOS_POSTING_CARD, &c.Carriers.Correios.PostingCard)
+	env.string(CORREIOS_BASE_URL, &c.Carriers.Correios.BaseURL)
+	env.string(JADLOG_TOKEN, &c.Carriers.Jadlog.Token)
+	env.string(JADLOG_BASE_URL, &c.Carriers.Jadlog.BaseURL)
+	env.string(LOGGI_TOKEN, &c.Carriers.Loggi.Token)
+	env.string(LOGGI_COMPANY_ID, &c.Carriers.Loggi.CompanyID)
+	env.string(LOGGI_BASE_URL, &c.Carriers.Loggi.BaseURL)
+
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		value := values[key]
+
+		if collection, ok := c.envSuffix(key, MONGODB_COLLECTION, database.Collections); ok {
+			if c.Mongo.Collections == nil {
+				c.Mongo.Collections = make(map[string]string)
+			}
+			c.Mongo.Collections[collection] = value
+			continue
+		}
+
+		if entity, ok := c.envSuffix(key, DELETE_POLICY, deleteEntities); ok {
+			if c.Delete.Entities == nil {
+				c.Delete.Entities = make(map[string]string)
+			}
+			c.Delete.Entities[entity] = value
+			continue
+		}
+
+		// The longer prefixes go first: SCHEDULE_JITTER_USERS is the jitter of
+		// users, not the schedule of a job named jitter_users.
+		switch {
+		case strings.HasPrefix(key, SCHEDULE_JITTER+"_"):
+			if job, ok := c.envSuffix(key, SCHEDULE_JITTER, jobs); ok {
+				c.updateJobSchedule(job, func(schedule *ScheduleConfig) {
+					env.optionalDuration(key, &schedule.Jitter)
+				})
+			}
+		case strings.HasPrefix(key, SCHEDULE_ENABLED+"_"):
+			if job, ok := c.envSuffix(key, SCHEDULE_ENABLED, jobs); ok {
+				c.updateJobSchedule(job, func(schedule *ScheduleConfig) {
+					env.optionalBool(key, &schedule.Enabled)
+				})
+			}
+		case key != SCHEDULE_JITTER && key != SCHEDULE_ENABLED:
+			if job, ok := c.envSuffix(key, SCHEDULE, jobs); ok {
+				c.updateJobSchedule(job, func(schedule *ScheduleConfig) {
+					schedule.Spec = value
+				})
+			}
+		}
+	}
+
+	return env.errs
+}
+
+// envSuffix returns the lowercased suffix of key after prefix and an
+// underscore, as in DELETE_POLICY_LEADS. A suffix that is not one of names is
+// only warned about: the environment is shared with other programs, and a
+// variable that merely starts like one of ours must not stop the daemon.
+func (c *Config) envSuffix(key, prefix string, names []string) (string, bool) {
+	suffix, ok := strings.CutPrefix(key, prefix+"_")
+	if !ok {
+		return "", false
+	}
+	name := strings.ToLower(suffix)
+	if !slices.Contains(names, name) {
+		c.warnings = append(c.warnings, fmt.Sprintf("%s ignored: %q is not one of %s", key, name, strings.Join(names, ", ")))
+		return "", false
+	}
+	return name, true
+}
+
+// Warnings lists the settings Load ignored, to be logged once the logger is
+// set up.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// updateJobSchedule applies update to the schedule of job, creating the entry
+// when the config file had none.
+func (c *Config) updateJobSchedule(job string, update func(*ScheduleConfig)) {
+	if c.Schedules.Jobs == nil {
+		c.Schedules.Jobs = make(map[string]ScheduleConfig)
+	}
+	schedule := c.Schedules.Jobs[job]
+	update(&schedule)
+	c.Schedules.Jobs[job] = schedule
+}
+
+// envValues parses environment variables into typed settings, collecting the
+// errors instead of stopping at the first one. Unset and empty variables leave
+// the setting untouched.
+type envValues struct {
+	values map[string]string
+	errs   []error
+}
+
+func (e *envValues) lookup(key string) (string, bool) {
+	value := e.values[key]
+	return value, value != ""
+}
+
+func (e *envValues) invalid(key, value, expected string) {
+	e.errs = append(e.errs, fmt.Errorf("%s: invalid value %q, expected %s", key, value, expected))
+}
+
+func (e *envValues) string(key string, target *string) {
+	if value, ok := e.lookup(key); ok {
+		*target = value
+	}
+}
+
+func (e *envValues) int(key string, target *int) {
+	value, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		e.invalid(key, value, "an integer")
+		return
+	}
+	*target = parsed
+}
+
+func (e *envValues) float(key string, target *float64) {
+	value, ok := e.lookup(key)
+	if !ok {
+		return
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.invalid(key, value, "a number")
+		return
+	}
+	*target = parsed
+}
+
+func (e *envValues) bool(key string, target *bool) bool {
+	value, ok := e.lookup(key)
+	if !ok {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		e.invalid(key, value, "true or false")
+		return false
+	}
+	*target = parsed
+	return true
+}
+
+// optionalBool sets a setting whose absence means "inherit", so it is only
+// allocated when the variable holds a valid value.
+func (e *envValues) optionalBool(key string, target **bool) {
+	var parsed bool
+	if e.bool(key, &parsed) {
+		*target = &parsed
+	}
+}
+
+func (e *envValues) duration(key string, target *time.Duration) bool {
+	value, ok := e.lookup(key)
+	if !ok {
+		return false
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		e.invalid(key, value, "a duration such as 30s or 5m")
+		return false
+	}
+	*target = parsed
+	return true
+}
+
+func (e *envValues) optionalDuration(key string, target **time.Duration) {
+	var parsed time.Duration
+	if e.duration(key, &parsed) {
+		*target = &parsed
+	}
+}
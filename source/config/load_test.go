@@ -0,0 +1,224 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testJobs are the jobs main registers.
+var testJobs = []string{"users", "products", "leads", "budgets", "orders", "tracking", "carriers"}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// inTempDir runs the test from an empty directory, so Load finds the
+// config.yaml and .env the test writes there, and not those of the repo.
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	return dir
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, filepath.Join(dir, DEFAULT_CONFIG_FILE), `
+env: homolog
+mongodb_uri: mongodb://file
+mysql_uri: user:pass@tcp(file)/db
+tiny:
+  token: file-token
+http:
+  port: "9000"
+sync:
+  job_timeout: 5m
+  batch_size: 100
+`)
+	writeFile(t, filepath.Join(dir, DOTENV_FILE), `
+# .env overrides the file
+SYNC_BATCH_SIZE=200
+SYNC_WRITE_TIMEOUT="30s"
+PORT=9100
+`)
+	t.Setenv(PORT, "9200")
+	t.Setenv(CONFIG_FILE, "")
+
+	cfg, err := Load("", testJobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Env != ENV_HOMOLOG || cfg.MongoDBURI != "mongodb://file" {
+		t.Errorf("file settings not loaded: env %q, mongodb_uri %q", cfg.Env, cfg.MongoDBURI)
+	}
+	if cfg.Sync.JobTimeout != 5*time.Minute {
+		t.Errorf("job_timeout = %v, want the file's 5m", cfg.Sync.JobTimeout)
+	}
+	if cfg.Sync.BatchSize != 200 || cfg.Sync.WriteTimeout != 30*time.Second {
+		t.Errorf("batch_size = %d, write_timeout = %v; want .env's 200 and 30s", cfg.Sync.BatchSize, cfg.Sync.WriteTimeout)
+	}
+	if cfg.HTTP.Port != "9200" {
+		t.Errorf("port = %q, want the environment's 9200", cfg.HTTP.Port)
+	}
+	if cfg.Sync.RunsRetentionDays != DEFAULT_SYNC_RUNS_RETENTION_DAYS {
+		t.Errorf("runs_retention_days = %d, want the default", cfg.Sync.RunsRetentionDays)
+	}
+}
+
+func TestLoadBackoffSettings(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, filepath.Join(dir, DEFAULT_CONFIG_FILE), `
+env: homolog
+mongodb_uri: mongodb://file
+mysql_uri: user:pass@tcp(file)/db
+tiny:
+  token: file-token
+tracking:
+  max_attempts: 10
+carriers:
+  backoff: 2h
+  backoff_max: 48h
+`)
+	t.Setenv(CONFIG_FILE, "")
+	t.Setenv(CARRIERS_MAX_ATTEMPTS, "5")
+
+	cfg, err := Load("", testJobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Tracking.MaxAttempts != 10 || cfg.Tracking.Backoff != DEFAULT_TRACKING_BACKOFF {
+		t.Errorf("tracking = %+v, want max_attempts 10 and the default backoff", cfg.Tracking)
+	}
+	if cfg.Carriers.Backoff != 2*time.Hour || cfg.Carriers.BackoffMax != 48*time.Hour || cfg.Carriers.MaxAttempts != 5 {
+		t.Errorf("carriers backoff = %+v, want 2h, 48h and 5 attempts", cfg.Carriers.BackoffConfig)
+	}
+}
+
+func TestLoadEmptyVariableKeepsSetting(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, filepath.Join(dir, DEFAULT_CONFIG_FILE), "env: homolog\nmongodb_uri: mongodb://file\nmysql_uri: db\ntiny:\n  token: file-token\n")
+	t.Setenv(CONFIG_FILE, "")
+	t.Setenv(MONGODB_URI, "")
+
+	cfg, err := Load("", testJobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MongoDBURI != "mongodb://file" {
+		t.Errorf("mongodb_uri = %q, want the file's value kept", cfg.MongoDBURI)
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	dir := inTempDir(t)
+	secret := filepath.Join(dir, "mysql_uri")
+	writeFile(t, secret, "user:secret@tcp(db)/app\n")
+	t.Setenv(CONFIG_FILE, "")
+	t.Setenv(ENV, ENV_HOMOLOG)
+	t.Setenv(MONGODB_URI, "mongodb://env")
+	t.Setenv(TINY_TOKEN, "env-token")
+	t.Setenv(MYSQL_URI+FILE_SUFFIX, secret)
+
+	cfg, err := Load("", testJobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MySQLURI != "user:secret@tcp(db)/app" {
+		t.Errorf("mysql_uri = %q, want the file content", cfg.MySQLURI)
+	}
+
+	t.Setenv(MYSQL_URI, "user:other@tcp(db)/app")
+	if _, err := Load("", testJobs); err == nil || !strings.Contains(err.Error(), "both set") {
+		t.Errorf("error = %v, want both variables rejected", err)
+	}
+}
+
+func TestLoadReportsEveryError(t *testing.T) {
+	inTempDir(t)
+	t.Setenv(CONFIG_FILE, "")
+	t.Setenv(ENV, "staging")
+	t.Setenv(MONGODB_URI, "")
+	t.Setenv(MYSQL_URI, "")
+	t.Setenv(SYNC_BATCH_SIZE, "many")
+
+	_, err := Load("", testJobs)
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, key := range []string{ENV, MONGODB_URI, MYSQL_URI, SYNC_BATCH_SIZE} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error does not mention %s:\n%v", key, err)
+		}
+	}
+}
+
+func TestLoadMissingConfigFile(t *testing.T) {
+	inTempDir(t)
+	t.Setenv(CONFIG_FILE, "missing.yaml")
+
+	if _, err := Load("", testJobs); err == nil {
+		t.Error("a config file given explicitly may not be missing")
+	}
+}
+
+func TestApplyEnvSuffixes(t *testing.T) {
+	cfg := Default()
+	errs := cfg.applyEnv(map[string]string{
+		MONGODB_COLLECTION + "_ORDERS": "orders_rehearsal",
+		DELETE_POLICY + "_LEADS":       DELETE_POLICY_SOFT,
+		SCHEDULE + "_TRACKING":         "1h",
+		SCHEDULE_JITTER + "_USERS":     "10s",
+		SCHEDULE_ENABLED + "_CARRIERS": "false",
+		SCHEDULE_JITTER:                "5s",
+
+		// Variables of other programs, or typos, are only warned about.
+		MONGODB_COLLECTION + "_ORDRES": "x",
+		DELETE_POLICY + "_TRACKING":    DELETE_POLICY_OFF,
+		SCHEDULE + "_BACKUP":           "@daily",
+		SCHEDULE_JITTER + "_BACKUP":    "1m",
+	}, testJobs)
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if err := cfg.Validate(); err != nil && strings.Contains(err.Error(), "unknown") {
+		t.Errorf("unknown suffixes reached Validate: %v", err)
+	}
+
+	if cfg.Mongo.Collections["orders"] != "orders_rehearsal" {
+		t.Errorf("collections = %v", cfg.Mongo.Collections)
+	}
+	if cfg.Delete.Entities["leads"] != DELETE_POLICY_SOFT || len(cfg.Delete.Entities) != 1 {
+		t.Errorf("delete entities = %v", cfg.Delete.Entities)
+	}
+	if cfg.Schedules.For("tracking").Spec != "1h" {
+		t.Errorf("tracking schedule = %+v", cfg.Schedules.For("tracking"))
+	}
+	if jitter := cfg.Schedules.Jobs["users"].Jitter; jitter == nil || *jitter != 10*time.Second {
+		t.Errorf("users jitter = %v, want 10s", jitter)
+	}
+	if enabled := cfg.Schedules.Jobs["carriers"].Enabled; enabled == nil || *enabled {
+		t.Errorf("carriers enabled = %v, want false", enabled)
+	}
+	if _, ok := cfg.Schedules.Jobs["backup"]; ok {
+		t.Error("a schedule was created for an unknown job")
+	}
+
+	warnings := cfg.Warnings()
+	if len(warnings) != 4 {
+		t.Fatalf("warnings = %q, want one per unknown suffix", warnings)
+	}
+	for _, key := range []string{"MONGODB_COLLECTION_ORDRES", "DELETE_POLICY_TRACKING", "SCHEDULE_BACKUP", "SCHEDULE_JITTER_BACKUP"} {
+		if !slices.ContainsFunc(warnings, func(warning string) bool { return strings.HasPrefix(warning, key+" ") }) {
+			t.Errorf("no warning about %s in %q", key, warnings)
+		}
+	}
+}
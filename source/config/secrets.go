@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// FILE_SUFFIX turns a secret variable into the path of a file holding it, as
+// mounted by Docker and Kubernetes secrets: MYSQL_URI_FILE=/run/secrets/mysql.
+const FILE_SUFFIX = "_FILE"
+
+const REDACTED = "[REDACTED]"
+
+// secretKeys are the variables that accept a _FILE variant and whose values
+// never reach the logs.
+var secretKeys = []string{MONGODB_URI, MYSQL_URI, TINY_TOKEN, TINY_WEBHOOK_SECRET, CORREIOS_ACCESS_CODE, JADLOG_TOKEN, LOGGI_TOKEN}
+
+// readSecretFiles replaces every <KEY>_FILE in values by <KEY>, set to the
+// content of the file without its trailing newline. Setting both is an error,
+// since one of them would be silently ignored.
+func readSecretFiles(values map[string]string) []error {
+	var errs []error
+	for _, key := range secretKeys {
+		path := values[key+FILE_SUFFIX]
+		if path == "" {
+			continue
+		}
+		if values[key] != "" {
+			errs = append(errs, fmt.Errorf("%s and %s%s are both set, keep only one", key, key, FILE_SUFFIX))
+			continue
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", key, FILE_SUFFIX, err))
+			continue
+		}
+
+		value := strings.TrimRight(string(content), "\r\n")
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s%s: %s is empty", key, FILE_SUFFIX, path))
+			continue
+		}
+		values[key] = value
+	}
+	return errs
+}
+
+// Redact replaces the secrets of the configuration found in text. The
+// connection URIs are masked whole and, when they appear in pieces, so are
+// their passwords.
+func (c *Config) Redact(text string) string {
+	redactor := c.redactor
+	if redactor == nil {
+		redactor = c.newRedactor()
+	}
+	return redactor.Replace(text)
+}
+
+// RedactError returns err with its message redacted. It still unwraps to err,
+// so errors.Is keeps working on it.
+func (c *Config) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := c.Redact(err.Error())
+	if message == err.Error() {
+		return err
+	}
+	return &redactedError{message: message, err: err}
+}
+
+type redactedError struct {
+	message string
+	err     error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// newRedactor lists the whole secrets before their parts, since a Replacer
+// prefers the earlier pair when two match at the same position. Passwords
+// are only masked where a URI or DSN puts them, between the user's colon and
+// the @, so a short local password such as "x" hides that x and not every x
+// of every log line.
+func (c *Config) newRedactor() *strings.Replacer {
+	secrets := []string{
+		c.MongoDBURI, c.MySQLURI, c.Tiny.Token, c.Tiny.WebhookSecret,
+		c.Carriers.Correios.AccessCode, c.Carriers.Jadlog.Token, c.Carriers.Loggi.Token,
+	}
+
+	var pairs []string
+	for _, secret := range secrets {
+		if secret != "" {
+			pairs = append(pairs, secret, REDACTED)
+		}
+	}
+
+	var passwords []string
+	if uri, err := url.Parse(c.MongoDBURI); err == nil && uri.User != nil {
+		if password, ok := uri.User.Password(); ok {
+			passwords = append(passwords, password, url.QueryEscape(password))
+		}
+	}
+	if dsn, err := mysql.ParseDSN(c.MySQLURI); err == nil {
+		passwords = append(passwords, dsn.Passwd)
+	}
+	for _, password := range passwords {
+		if password != "" {
+			pairs = append(pairs, ":"+password+"@", ":"+REDACTED+"@")
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
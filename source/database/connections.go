@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const CONNECTION_PING_TIMEOUT = 10 * time.Second
+
+// Connections owns the long-lived MySQL pools and MongoDB client shared by
+// every sync job. Budgets read DATETIME columns as time.Time, so they get a
+// second pool opened with parseTime=true; the other jobs parse the raw bytes.
+type Connections struct {
+	mongoDatabase    string
+	mongoCollections map[string]string
+
+	mysql          *sql.DB
+	mysqlParseTime *sql.DB
+	mongo          *mongo.Client
+}
+
+// Connect opens both databases. mongoDatabase is the MongoDB database the
+// jobs write to and mongoCollections maps logical collection names to the
+// names to use instead.
+func Connect(ctx context.Context, mysqlURI, mongoURI, mongoDatabase string, mongoCollections map[string]string) (*Connections, error) {
+	c := &Connections{
+		mongoDatabase:    mongoDatabase,
+		mongoCollections: mongoCollections,
+	}
+
+	var err error
+	if c.mysql, err = openMySQL(ctx, mysqlURI); err != nil {
+		return nil, err
+	}
+	if c.mysqlParseTime, err = openMySQL(ctx, withParseTime(mysqlURI)); err != nil {
+		c.mysql.Close()
+		return nil, err
+	}
+	if c.mongo, err = openMongo(ctx, mongoURI); err != nil {
+		c.mysql.Close()
+		c.mysqlParseTime.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Connections) MySQL() *sql.DB {
+	return c.mysql
+}
+
+func (c *Connections) MySQLParseTime() *sql.DB {
+	return c.mysqlParseTime
+}
+
+func (c *Connections) Mongo() *mongo.Client {
+	return c.mongo
+}
+
+func (c *Connections) MongoDB() *MongoDB {
+	return &MongoDB{Database: c.Mongo().Database(c.mongoDatabase), collections: c.mongoCollections}
+}
+
+// Ping reports whether both MySQL and MongoDB are reachable right now. It
+// does not reconnect: *sql.DB and *mongo.Client replace broken connections on
+// their own once a restarted database answers again.
+func (c *Connections) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, CONNECTION_PING_TIMEOUT)
+	defer cancel()
+
+	if err := c.MySQL().PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping MySQL: %w", err)
+	}
+	if err := c.Mongo().Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+	return nil
+}
+
+func (c *Connections) Close(ctx context.Context) {
+	c.mysql.Close()
+	c.mysqlParseTime.Close()
+	c.mongo.Disconnect(ctx)
+}
+
+func openMySQL(ctx context.Context, uri string) (*sql.DB, error) {
+	mysqlDB, err := sql.Open("mysql", uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MySQL: %w", err)
+	}
+
+	mysqlDB.SetConnMaxLifetime(MYSQL_CONN_MAX_LIFETIME)
+	mysqlDB.SetMaxOpenConns(MYSQL_MAX_OPEN_CONNS)
+	mysqlDB.SetMaxIdleConns(MYSQL_MAX_IDLE_CONNS)
+
+	pingCtx, cancel := context.WithTimeout(ctx, CONNECTION_PING_TIMEOUT)
+	defer cancel()
+
+	if err := mysqlDB.PingContext(pingCtx); err != nil {
+		mysqlDB.Close()
+		return nil, fmt.Errorf("failed to ping MySQL: %w", err)
+	}
+
+	return mysqlDB, nil
+}
+
+func openMongo(ctx context.Context, uri string) (*mongo.Client, error) {
+	mongoClient, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, CONNECTION_PING_TIMEOUT)
+	defer cancel()
+
+	if err := mongoClient.Ping(pingCtx, nil); err != nil {
+		mongoClient.Disconnect(ctx)
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	return mongoClient, nil
+}
+
+func withParseTime(uri string) string {
+	if strings.Contains(uri, "parseTime=true") {
+		return uri
+	}
+	if strings.Contains(uri, "?") {
+		return uri + "&parseTime=true"
+	}
+	return uri + "?parseTime=true"
+}
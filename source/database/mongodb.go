@@ -1,33 +1,46 @@
 package database
 
-import (
-	"database_sync/utils"
-	"os"
-	"time"
-)
+import "go.mongodb.org/mongo-driver/v2/mongo"
 
-func GetDB() string {
-	environment := os.Getenv(utils.ENV)
+// The logical collection names. They are also the actual names unless the
+// configuration maps them elsewhere, see MongoDB.Collection.
+const (
+	COLLECTION_USERS    = "users"
+	COLLECTION_LEADS    = "leads"
+	COLLECTION_BUDGETS  = "budgets"
+	COLLECTION_ORDERS   = "orders"
+	COLLECTION_PRODUCTS = "products"
 
-	if environment == utils.ENV_RELEASE {
-		return utils.ENV_RELEASE
-	}
+	COLLECTION_SYNC_STATE   = "sync_state"
+	COLLECTION_SYNC_RUNS    = "sync_runs"
+	COLLECTION_SYNC_CHANGES = "sync_changes"
 
-	if environment == utils.ENV_HOMOLOG {
-		return utils.ENV_HOMOLOG
-	}
+	COLLECTION_WEBHOOK_EVENTS = "webhook_events"
+)
 
-	if environment == utils.ENV_DEVELOPMENT {
-		return utils.ENV_DEVELOPMENT
-	}
+var Collections = []string{
+	COLLECTION_USERS, COLLECTION_LEADS, COLLECTION_BUDGETS, COLLECTION_ORDERS, COLLECTION_PRODUCTS,
+	COLLECTION_SYNC_STATE, COLLECTION_SYNC_RUNS, COLLECTION_SYNC_CHANGES,
+	COLLECTION_WEBHOOK_EVENTS,
+}
 
-	panic("[MongoDB] Invalid DB name")
+// MongoDB is the database the jobs write to. Its Collection takes a logical
+// name and applies the configured overrides, so a rehearsal can sync into
+// orders_rehearsal while the code keeps asking for COLLECTION_ORDERS.
+type MongoDB struct {
+	*mongo.Database
+	collections map[string]string
 }
 
-const (
-	MONGODB_TIMEOUT    = 20 * time.Minute
-	COLLECTION_USERS   = "users"
-	COLLECTION_LEADS   = "leads"
-	COLLECTION_BUDGETS = "budgets"
-	COLLECTION_ORDERS  = "orders"
-)
+func (db *MongoDB) Collection(name string) *mongo.Collection {
+	return db.Database.Collection(db.CollectionName(name))
+}
+
+// CollectionName returns the actual name of a logical collection, for the
+// commands that take a collection name instead of a handle.
+func (db *MongoDB) CollectionName(name string) string {
+	if override, ok := db.collections[name]; ok {
+		return override
+	}
+	return name
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database_sync/config"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type DeletePolicy string
+
+const (
+	DeletePolicyHard DeletePolicy = config.DELETE_POLICY_HARD
+	DeletePolicySoft DeletePolicy = config.DELETE_POLICY_SOFT
+	DeletePolicyOff  DeletePolicy = config.DELETE_POLICY_OFF
+)
+
+// deleteTarget is the part of a collection reconcileDeletes writes to.
+type deleteTarget interface {
+	UpdateMany(ctx context.Context, filter, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter any, opts ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error)
+}
+
+// reconcileDeletes removes, flags or keeps the documents whose MySQL rows no
+// longer exist, according to the entity's delete policy. The whole run is
+// aborted when the deletion would exceed DeleteMaxPercent of the documents
+// currently in the collection, which usually means a broken MySQL read.
+func reconcileDeletes[T comparable](ctx context.Context, run *SyncRun, collection deleteTarget, key string, ids []T, total int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	entity := run.Entity
+
+	policy := run.DeletePolicy
+	if policy == DeletePolicyOff {
+		run.Logger.Info("documents no longer exist in MySQL, kept because delete policy is off", "count", len(ids))
+		return 0, nil
+	}
+
+	maxPercent := run.DeleteMaxPercent
+	if total > 0 {
+		percent := float64(len(ids)) / float64(total) * 100
+		if percent > maxPercent {
+			return 0, fmt.Errorf("refusing to delete %d of %d %s (%.1f%%), above the %.1f%% safety threshold",
+				len(ids), total, entity, percent, maxPercent)
+		}
+	}
+
+	if run.DryRun {
+		planDeletes(run.Report, policy, ids)
+		return 0, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	// Like a bulk write, a delete already decided is not interrupted by a
+	// shutdown.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), run.WriteTimeout)
+	defer cancel()
+
+	filter := bson.D{{Key: key, Value: bson.D{{Key: "$in", Value: ids}}}}
+
+	if policy == DeletePolicySoft {
+		filter = append(filter, bson.E{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}})
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "deleted_at", Value: time.Now()},
+			{Key: "deleted_by_sync", Value: true},
+		}}}
+
+		result, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return 0, fmt.Errorf("failed to soft delete non-existing %s from MongoDB: %w", entity, err)
+		}
+		for _, id := range ids {
+			run.Changes.Delete(id, true)
+		}
+		run.Changes.Flush(ctx)
+		return result.ModifiedCount, nil
+	}
+
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete non-existing %s from MongoDB: %w", entity, err)
+	}
+	for _, id := range ids {
+		run.Changes.Delete(id, false)
+	}
+	run.Changes.Flush(ctx)
+	return result.DeletedCount, nil
+}
+
+// upsertUpdate builds the $set update for a synced document. Documents the
+// synchronizer soft deleted earlier are restored when their row comes back.
+func upsertUpdate(set any, deletedBySync bool) bson.D {
+	update := bson.D{{Key: "$set", Value: set}}
+	if deletedBySync {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{
+			{Key: "deleted_at", Value: ""},
+			{Key: "deleted_by_sync", Value: ""},
+		}})
+	}
+	return update
+}
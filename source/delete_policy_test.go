@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// fakeDeleteTarget records the writes of reconcileDeletes instead of sending
+// them to MongoDB.
+type fakeDeleteTarget struct {
+	updates []bson.D
+	deletes []bson.D
+}
+
+func (f *fakeDeleteTarget) UpdateMany(_ context.Context, filter, _ any, _ ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	f.updates = append(f.updates, filter.(bson.D))
+	return &mongo.UpdateResult{ModifiedCount: 2}, nil
+}
+
+func (f *fakeDeleteTarget) DeleteMany(_ context.Context, filter any, _ ...options.Lister[options.DeleteManyOptions]) (*mongo.DeleteResult, error) {
+	f.deletes = append(f.deletes, filter.(bson.D))
+	return &mongo.DeleteResult{DeletedCount: 2}, nil
+}
+
+func newDeleteRun(policy DeletePolicy) *SyncRun {
+	run := NewSyncRun("leads", "cycle")
+	run.DeletePolicy = policy
+	run.DeleteMaxPercent = 20
+	run.WriteTimeout = time.Minute
+	return run
+}
+
+func TestReconcileDeletesThreshold(t *testing.T) {
+	target := &fakeDeleteTarget{}
+
+	_, err := reconcileDeletes(context.Background(), newDeleteRun(DeletePolicyHard), target, "old_id", []uint64{1, 2, 3}, 10)
+	if err == nil || !strings.Contains(err.Error(), "safety threshold") {
+		t.Fatalf("error = %v, want the safety threshold", err)
+	}
+	if len(target.deletes)+len(target.updates) != 0 {
+		t.Error("documents were written above the threshold")
+	}
+
+	deleted, err := reconcileDeletes(context.Background(), newDeleteRun(DeletePolicyHard), target, "old_id", []uint64{1, 2}, 10)
+	if err != nil || deleted != 2 || len(target.deletes) != 1 {
+		t.Errorf("deleted = %d, %v with %d deletes; want 2 at the threshold", deleted, err, len(target.deletes))
+	}
+}
+
+func TestReconcileDeletesPolicies(t *testing.T) {
+	t.Run("hard", func(t *testing.T) {
+		target := &fakeDeleteTarget{}
+		deleted, err := reconcileDeletes(context.Background(), newDeleteRun(DeletePolicyHard), target, "old_id", []uint64{1, 2}, 100)
+		if err != nil || deleted != 2 {
+			t.Fatalf("deleted = %d, %v; want 2", deleted, err)
+		}
+		if len(target.deletes) != 1 || len(target.updates) != 0 {
+			t.Fatalf("got %d deletes and %d updates, want one delete", len(target.deletes), len(target.updates))
+		}
+		if len(target.deletes[0]) != 1 || target.deletes[0][0].Key != "old_id" {
+			t.Errorf("filter = %v, want old_id only", target.deletes[0])
+		}
+	})
+
+	t.Run("soft", func(t *testing.T) {
+		target := &fakeDeleteTarget{}
+		flagged, err := reconcileDeletes(context.Background(), newDeleteRun(DeletePolicySoft), target, "old_id", []uint64{1, 2}, 100)
+		if err != nil || flagged != 2 {
+			t.Fatalf("flagged = %d, %v; want 2", flagged, err)
+		}
+		if len(target.updates) != 1 || len(target.deletes) != 0 {
+			t.Fatalf("got %d updates and %d deletes, want one update", len(target.updates), len(target.deletes))
+		}
+		if filter := target.updates[0]; len(filter) != 2 || filter[1].Key != "deleted_at" {
+			t.Errorf("filter = %v, want documents not deleted yet", filter)
+		}
+	})
+
+	t.Run("off", func(t *testing.T) {
+		target := &fakeDeleteTarget{}
+		// Off keeps documents even above the threshold.
+		deleted, err := reconcileDeletes(context.Background(), newDeleteRun(DeletePolicyOff), target, "old_id", []uint64{1, 2, 3}, 3)
+		if err != nil || deleted != 0 || len(target.updates)+len(target.deletes) != 0 {
+			t.Errorf("deleted = %d, %v with %d writes; want nothing", deleted, err, len(target.updates)+len(target.deletes))
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		target := &fakeDeleteTarget{}
+		run := newDeleteRun(DeletePolicySoft)
+		run.DryRun = true
+		run.Report = &DryRunReport{}
+		deleted, err := reconcileDeletes(context.Background(), run, target, "old_id", []uint64{1, 2}, 100)
+		if err != nil || deleted != 0 || len(target.updates)+len(target.deletes) != 0 {
+			t.Errorf("deleted = %d, %v with %d writes; want nothing", deleted, err, len(target.updates)+len(target.deletes))
+		}
+		if run.Report.Deletes != 2 || run.Report.DeletePolicy != DeletePolicySoft {
+			t.Errorf("report = %+v, want 2 soft deletes planned", run.Report)
+		}
+	})
+}
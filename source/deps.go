@@ -0,0 +1,17 @@
+package main
+
+import (
+	"database_sync/carriers"
+	"database_sync/config"
+	"database_sync/database"
+	"database_sync/tiny"
+)
+
+// SyncDeps carries what the sync jobs share for the lifetime of the process.
+// Tiny is shared too, so every caller draws from the same rate limit.
+type SyncDeps struct {
+	Config      *config.Config
+	Connections *database.Connections
+	Tiny        *tiny.Client
+	Carriers    *carriers.Registry
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"database_sync/database"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const DRY_RUN_SAMPLE_SIZE = 5
+
+// DryRunReport is what a job would have written. Samples hold the filter and
+// update of the first upserts, as relaxed extended JSON.
+type DryRunReport struct {
+	Upserts          int               `json:"upserts"`
+	Deletes          int               `json:"deletes"`
+	DeletePolicy     DeletePolicy      `json:"delete_policy,omitempty"`
+	SampleUpserts    []DryRunSample    `json:"sample_upserts,omitempty"`
+	SampleDeletedIDs []any             `json:"sample_deleted_ids,omitempty"`
+	SkippedWrites    map[string]string `json:"skipped_writes,omitempty"`
+}
+
+type DryRunSample struct {
+	Filter json.RawMessage `json:"filter"`
+	Update json.RawMessage `json:"update"`
+}
+
+func (r *DryRunReport) planUpserts(models []mongo.WriteModel) {
+	for _, model := range models {
+		r.Upserts++
+		updateModel, ok := model.(*mongo.UpdateOneModel)
+		if !ok || len(r.SampleUpserts) >= DRY_RUN_SAMPLE_SIZE {
+			continue
+		}
+		r.SampleUpserts = append(r.SampleUpserts, DryRunSample{
+			Filter: extendedJSON(updateModel.Filter),
+			Update: extendedJSON(updateModel.Update),
+		})
+	}
+}
+
+func planDeletes[T comparable](r *DryRunReport, policy DeletePolicy, ids []T) {
+	r.Deletes += len(ids)
+	r.DeletePolicy = policy
+	for _, id := range ids {
+		if len(r.SampleDeletedIDs) >= DRY_RUN_SAMPLE_SIZE {
+			break
+		}
+		r.SampleDeletedIDs = append(r.SampleDeletedIDs, id)
+	}
+}
+
+// skipWrite notes a write that has no preview, such as a watermark update.
+func (r *DryRunReport) skipWrite(name, description string) {
+	if r.SkippedWrites == nil {
+		r.SkippedWrites = make(map[string]string)
+	}
+	r.SkippedWrites[name] = description
+}
+
+func extendedJSON(value any) json.RawMessage {
+	raw, err := bson.MarshalExtJSON(value, false, false)
+	if err != nil {
+		raw, _ = json.Marshal(fmt.Sprintf("unprintable document: %v", err))
+	}
+	return raw
+}
+
+// BulkWrite applies the models, or only reports them in dry-run mode. Every
+// job writes through it, so the stats and the change log stay in step with
+// what actually reached MongoDB.
+//
+// Once ctx is canceled no new batch starts, but a batch already sent is not
+// interrupted: it gets WriteTimeout to finish, so a shutdown never
+// leaves half of a batch applied.
+func (r *SyncRun) BulkWrite(ctx context.Context, collection *mongo.Collection, models []mongo.WriteModel) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if r.DryRun {
+		r.Report.planUpserts(models)
+		return nil
+	}
+
+	writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.WriteTimeout)
+	defer cancel()
+
+	result, err := collection.BulkWrite(writeCtx, models)
+	if err != nil {
+		return err
+	}
+	r.RecordBulkWrite(result)
+	r.Changes.Flush(writeCtx)
+	return nil
+}
+
+// CommitSyncState saves the watermarks of a successful run. Dry runs leave
+// them untouched, so the real run that follows sees the same changes.
+func (r *SyncRun) CommitSyncState(ctx context.Context, db *database.MongoDB, fullSync bool, startedAt time.Time, states ...*SyncState) error {
+	for _, state := range states {
+		if r.DryRun {
+			r.Report.skipWrite("sync_state."+state.Entity, fmt.Sprintf("watermark %s / %s", state.LastUpdatedAt.Format(time.RFC3339), state.LastID))
+			continue
+		}
+		if err := state.Commit(ctx, db, fullSync, startedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
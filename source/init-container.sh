@@ -1,12 +1,9 @@
 #!/bin/bash
 
-echo "" >> .env
-echo "MONGODB_URI=$MONGODB_URI" >> .env
-echo "ENV=$ENV" >> .env
-echo "MYSQL_URI=$MYSQL_URI" >> .env
-
-
+# The synchronizer reads its settings from the container environment, and from
+# CONFIG_FILE when one is mounted, so nothing has to be written to .env here.
 echo "[arte arena security] Configurando variáveis de ambiente..."
 
-/app/main
-tail -f /dev/null
+# exec replaces the shell, so SIGTERM reaches the synchronizer and the
+# container exits with it.
+exec /app/main "$@"
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database_sync/config"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// JobSchedule says when the daemon starts a job. Spec is either a Go
+// duration ("10m"), which runs the job at that interval, or a cron
+// expression ("0 3 * * *", "@hourly", "@every 1h", "CRON_TZ=America/Sao_Paulo 0 8 * * *").
+// Jitter delays every start by a random amount up to its value, so jobs
+// sharing a schedule do not hit MySQL at the same instant.
+type JobSchedule struct {
+	Spec     string
+	Jitter   time.Duration
+	Enabled  bool
+	schedule cron.Schedule
+}
+
+type JobScheduleStatus struct {
+	Spec    string    `json:"spec"`
+	Jitter  string    `json:"jitter,omitempty"`
+	Enabled bool      `json:"enabled"`
+	NextRun time.Time `json:"next_run,omitzero"`
+}
+
+// LoadJobSchedule parses the configured schedule of job. The config package
+// only checks the jitter; the spec is checked here, where cron is known.
+func LoadJobSchedule(job string, settings config.ScheduleSettings) (JobSchedule, error) {
+	schedule, err := parseScheduleSpec(settings.Spec)
+	if err != nil {
+		return JobSchedule{}, fmt.Errorf("invalid schedule %q for %s: %w", settings.Spec, job, err)
+	}
+
+	return JobSchedule{Spec: settings.Spec, Jitter: settings.Jitter, Enabled: settings.Enabled, schedule: schedule}, nil
+}
+
+func parseScheduleSpec(spec string) (cron.Schedule, error) {
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval must be positive")
+		}
+		return cron.Every(interval), nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+// Next returns when the job starts next, jitter included.
+func (s JobSchedule) Next(now time.Time) time.Time {
+	next := s.schedule.Next(now)
+	if s.Jitter > 0 {
+		next = next.Add(rand.N(s.Jitter))
+	}
+	return next
+}
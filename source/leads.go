@@ -4,15 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"database_sync/database"
-	"database_sync/utils"
 	"fmt"
-	"os"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoDBLeads struct {
@@ -33,6 +29,8 @@ type MongoDBLeads struct {
 	Rating         string          `json:"classification,omitempty" bson:"classification,omitempty"`
 	Notes          string          `json:"notes,omitempty" bson:"notes,omitempty"`
 	Responsible    bson.ObjectID   `json:"responsible,omitempty" bson:"responsible,omitempty"`
+	DeletedAt      *time.Time      `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBySync  bool            `json:"deleted_by_sync,omitempty" bson:"deleted_by_sync,omitempty"`
 }
 
 type MySQLLeads struct {
@@ -44,37 +42,32 @@ type MySQLLeads struct {
 	UpdatedAt time.Time `db:"updated_at"`
 }
 
-func SyncLeads() error {
-	mysqlURI := os.Getenv("MYSQL_URI")
+func SyncLeads(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	mysqlDB := deps.Connections.MySQL()
 
-	mysqlDB, err := sql.Open("mysql", mysqlURI)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
-	}
-	defer mysqlDB.Close()
-
-	mysqlDB.SetConnMaxLifetime(database.MYSQL_CONN_MAX_LIFETIME)
-	mysqlDB.SetMaxOpenConns(database.MYSQL_MAX_OPEN_CONNS)
-	mysqlDB.SetMaxIdleConns(database.MYSQL_MAX_IDLE_CONNS)
-
-	if err := mysqlDB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping MySQL: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), database.MONGODB_TIMEOUT)
+	ctx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
 	defer cancel()
 
-	mongoURI := os.Getenv(utils.MONGODB_URI)
-	opts := options.Client().ApplyURI(mongoURI)
-	mongoClient, err := mongo.Connect(opts)
+	mongoDB := deps.Connections.MongoDB()
+
+	state, err := LoadSyncState(ctx, mongoDB, database.COLLECTION_LEADS)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return err
 	}
-	defer mongoClient.Disconnect(ctx)
+	startedAt := time.Now()
+	fullSync := run.ForceFullSync || state.NeedsFullSync(startedAt, deps.Config.Sync.FullReconciliationInterval)
 
 	allLeadsMap := make(map[string]*MySQLLeads, 20000)
 
-	dataRows, err := mysqlDB.Query("SELECT id, nome, email, telefone, created_at, updated_at FROM octa_webhook WHERE id IS NOT NULL")
+	query := "SELECT id, nome, email, telefone, created_at, updated_at FROM octa_webhook WHERE id IS NOT NULL"
+	var args []any
+	if !fullSync {
+		condition, conditionArgs := state.WhereChanged("updated_at", "id")
+		query += " AND " + condition
+		args = conditionArgs
+	}
+
+	dataRows, err := mysqlDB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to query MySQL octa_webhook data: %w", err)
 	}
@@ -115,12 +108,14 @@ func SyncLeads() error {
 		}
 
 		allLeadsMap[lead.ID] = lead
+		state.Advance(lead.UpdatedAt, lead.ID)
 	}
 	dataRows.Close()
 
 	if err = dataRows.Err(); err != nil {
 		return fmt.Errorf("error iterating MySQL data rows: %w", err)
 	}
+	run.Stats.MySQLRows = len(allLeadsMap)
 
 	mysqlIDs := make(map[string]bool, len(allLeadsMap))
 	for id := range allLeadsMap {
@@ -128,16 +123,27 @@ func SyncLeads() error {
 	}
 
 	if len(mysqlIDs) == 0 {
-		fmt.Printf("[SYNC_LEADS] Nenhum registro encontrado no MySQL para sincronizar: %s\n",
-			time.Now().Format("2006-01-02 15:04:05"))
+		if !fullSync {
+			return nil
+		}
+		run.Logger.Info("no records found in MySQL to synchronize")
 		return nil
 	}
 
-	leadsCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_LEADS)
+	leadsCollection := mongoDB.Collection(database.COLLECTION_LEADS)
 	mongoIDs := make(map[string]bool, 20000)
 	mongoLeadsData := make(map[string]MongoDBLeads)
 
-	cursor, err := leadsCollection.Find(ctx, bson.D{})
+	mongoFilter := bson.D{}
+	if !fullSync {
+		changedIDs := make([]string, 0, len(mysqlIDs))
+		for id := range mysqlIDs {
+			changedIDs = append(changedIDs, id)
+		}
+		mongoFilter = bson.D{{Key: "platform_id", Value: bson.D{{Key: "$in", Value: changedIDs}}}}
+	}
+
+	cursor, err := leadsCollection.Find(ctx, mongoFilter)
 	if err != nil {
 		return fmt.Errorf("failed to query MongoDB leads: %w", err)
 	}
@@ -148,8 +154,11 @@ func SyncLeads() error {
 		if err := cursor.Decode(&lead); err != nil {
 			return fmt.Errorf("failed to decode MongoDB lead: %w", err)
 		}
+		run.Stats.MongoDocs++
 		if lead.PlatformId != "" {
-			mongoIDs[lead.PlatformId] = true
+			if lead.DeletedAt == nil {
+				mongoIDs[lead.PlatformId] = true
+			}
 			mongoLeadsData[lead.PlatformId] = lead
 		}
 	}
@@ -159,19 +168,19 @@ func SyncLeads() error {
 	}
 
 	idsToDelete := []string{}
-	for mongoID := range mongoIDs {
-		if !mysqlIDs[mongoID] {
-			idsToDelete = append(idsToDelete, mongoID)
+	if fullSync {
+		for mongoID := range mongoIDs {
+			if !mysqlIDs[mongoID] {
+				idsToDelete = append(idsToDelete, mongoID)
+			}
 		}
 	}
 
-	if len(idsToDelete) > 0 {
-		deleteFilter := bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: idsToDelete}}}}
-		_, err := leadsCollection.DeleteMany(ctx, deleteFilter)
-		if err != nil {
-			return fmt.Errorf("failed to delete non-existing leads from MongoDB: %w", err)
-		}
+	deleted, err := reconcileDeletes(ctx, run, leadsCollection, "platform_id", idsToDelete, len(mongoIDs))
+	if err != nil {
+		return err
 	}
+	run.Stats.Deleted = deleted
 
 	recordsToUpsert := make([]string, 0)
 
@@ -193,26 +202,22 @@ func SyncLeads() error {
 
 		if mysqlName != mongoLead.Name ||
 			mysqlPhone != mongoLead.Phone ||
-			!mysqlLead.UpdatedAt.Equal(mongoLead.UpdatedAt) {
+			!mysqlLead.UpdatedAt.Equal(mongoLead.UpdatedAt) ||
+			mongoLead.DeletedBySync {
 			recordsToUpsert = append(recordsToUpsert, id)
 		}
 	}
 
+	run.Stats.Skipped = len(allLeadsMap) - len(recordsToUpsert)
+
 	if len(recordsToUpsert) == 0 {
-		return nil
+		return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state)
 	}
 
 	totalRecords := len(recordsToUpsert)
-	batchSize := 50
-	if totalRecords > 1000 {
-		batchSize = 200
-	} else if totalRecords > 5000 {
-		batchSize = 500
-	}
+	batchSize := deps.Config.Sync.BatchSizeFor(totalRecords)
 
 	bulkOperations := []mongo.WriteModel{}
-	processedCount := 0
-	bulkWriteCount := 0
 
 	for _, id := range recordsToUpsert {
 		lead := allLeadsMap[id]
@@ -234,7 +239,8 @@ func SyncLeads() error {
 		}
 
 		filter := bson.D{{Key: "platform_id", Value: mongoLead.PlatformId}}
-		update := bson.D{{Key: "$set", Value: mongoLead}}
+		update := upsertUpdate(mongoLead, mongoLeadsData[id].DeletedBySync)
+		run.Changes.Upsert(id, snapshotOf(mongoLeadsData, id), mongoLead)
 
 		upsertModel := mongo.NewUpdateOneModel().
 			SetFilter(filter).
@@ -242,25 +248,20 @@ func SyncLeads() error {
 			SetUpsert(true)
 
 		bulkOperations = append(bulkOperations, upsertModel)
-		processedCount++
 
 		if len(bulkOperations) >= batchSize {
-			_, err := leadsCollection.BulkWrite(ctx, bulkOperations)
-			if err != nil {
+			if err := run.BulkWrite(ctx, leadsCollection, bulkOperations); err != nil {
 				return fmt.Errorf("failed to execute bulk write: %w", err)
 			}
-			bulkWriteCount++
 			bulkOperations = []mongo.WriteModel{}
 		}
 	}
 
 	if len(bulkOperations) > 0 {
-		_, err := leadsCollection.BulkWrite(ctx, bulkOperations)
-		if err != nil {
+		if err := run.BulkWrite(ctx, leadsCollection, bulkOperations); err != nil {
 			return fmt.Errorf("failed to execute final bulk write: %w", err)
 		}
-		bulkWriteCount++
 	}
 
-	return nil
+	return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state)
 }
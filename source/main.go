@@ -1,135 +1,117 @@
 package main
 
 import (
+	"context"
+	"database_sync/config"
+	"database_sync/database"
+	"database_sync/tiny"
 	"database_sync/utils"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
 	"sync"
-	"time"
+	"syscall"
 )
 
 var (
+	usersSync    sync.Mutex
+	productsSync sync.Mutex
 	leadsSync    sync.Mutex
 	budgetsSync  sync.Mutex
 	ordersSync   sync.Mutex
 	trackingSync sync.Mutex
+	carriersSync sync.Mutex
 
+	isUsersSyncing    bool
+	isProductsSyncing bool
 	isLeadsSyncing    bool
 	isBudgetsSyncing  bool
 	isOrdersSyncing   bool
 	isTrackingSyncing bool
+	isCarriersSyncing bool
 )
 
-func main() {
-	utils.LoadEnvVariables()
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		fmt.Println("=== Starting synchronization cycle ===")
-
-		go func() {
-			leadsSync.Lock()
-			if isLeadsSyncing {
-				fmt.Println("Leads synchronization already in progress, skipping...")
-				leadsSync.Unlock()
-				return
-			}
-			isLeadsSyncing = true
-			leadsSync.Unlock()
-
-			defer func() {
-				leadsSync.Lock()
-				isLeadsSyncing = false
-				leadsSync.Unlock()
-			}()
+// syncJobs are the jobs of the synchronizer. Their names are also the
+// suffixes of the per-job settings, as in SCHEDULE_TRACKING.
+func syncJobs() []*SyncJob {
+	return []*SyncJob{
+		{Name: "users", Run: SyncUsers, lock: &usersSync, running: &isUsersSyncing},
+		{Name: "products", Run: SyncProducts, lock: &productsSync, running: &isProductsSyncing},
+		{Name: "leads", DependsOn: []string{"users"}, Run: SyncLeads, lock: &leadsSync, running: &isLeadsSyncing},
+		{Name: "budgets", DependsOn: []string{"users", "products", "leads"}, Run: SyncBudgets, lock: &budgetsSync, running: &isBudgetsSyncing},
+		{Name: "orders", DependsOn: []string{"users", "products", "budgets"}, Run: SyncOrders, lock: &ordersSync, running: &isOrdersSyncing},
+		{Name: "tracking", DependsOn: []string{"orders"}, Run: SyncOrdersTracking, lock: &trackingSync, running: &isTrackingSyncing},
+		{Name: "carriers", DependsOn: []string{"tracking"}, Run: SyncCarrierTracking, lock: &carriersSync, running: &isCarriersSyncing},
+	}
+}
 
-			fmt.Println("Running scheduled leads synchronization...")
-			startTime := time.Now()
-			if err := SyncLeads(); err != nil {
-				log.Printf("Error synchronizing leads: %v", err)
-			} else {
-				elapsed := time.Since(startTime)
-				fmt.Printf("Leads synchronization completed successfully (elapsed time: %s)\n", elapsed)
-			}
-		}()
+func main() {
+	command, args, options, code := ParseCommandLine(os.Args[1:], os.Stderr)
+	if command == nil {
+		os.Exit(code)
+	}
 
-		go func() {
-			budgetsSync.Lock()
-			if isBudgetsSyncing {
-				fmt.Println("Budgets synchronization already in progress, skipping budgets and orders...")
-				budgetsSync.Unlock()
-				return
-			}
-			isBudgetsSyncing = true
-			budgetsSync.Unlock()
+	jobs := syncJobs()
+	cfg, err := config.Load(options.ConfigFile, jobNames(jobs))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(EXIT_USAGE)
+	}
 
-			defer func() {
-				budgetsSync.Lock()
-				isBudgetsSyncing = false
-				budgetsSync.Unlock()
-			}()
+	// One-shot commands print their result on stdout, so their logs go to
+	// stderr to keep the output parseable.
+	logOutput := os.Stderr
+	if command.Name == "daemon" {
+		logOutput = os.Stdout
+	}
+	utils.SetupLogger(logOutput, cfg.Log, cfg.Redact)
+	for _, warning := range cfg.Warnings() {
+		slog.Warn("configuration setting ignored", "reason", warning)
+	}
 
-			fmt.Println("Running scheduled budgets synchronization...")
-			startTime := time.Now()
-			if err := SyncBudgets(); err != nil {
-				log.Printf("Error synchronizing budgets: %v", err)
-				return
-			} else {
-				elapsed := time.Since(startTime)
-				fmt.Printf("Budgets synchronization completed successfully (elapsed time: %s)\n", elapsed)
-			}
+	deps := &SyncDeps{
+		Config: cfg,
+		Tiny: tiny.NewClient(tiny.Options{
+			Token:             cfg.Tiny.Token,
+			BaseURL:           cfg.Tiny.BaseURL,
+			RequestsPerMinute: cfg.Tiny.RequestsPerMinute,
+			Timeout:           cfg.Tiny.Timeout,
+			MaxRetries:        cfg.Tiny.MaxRetries,
+			Observe:           observeTinyRequest,
+		}),
+		Carriers: NewCarrierRegistry(cfg.Carriers),
+	}
 
-			ordersSync.Lock()
-			if isOrdersSyncing {
-				fmt.Println("Orders synchronization already in progress, skipping...")
-				ordersSync.Unlock()
-				return
-			}
-			isOrdersSyncing = true
-			ordersSync.Unlock()
+	scheduler, err := NewScheduler(deps, jobs...)
+	if err != nil {
+		slog.Error("invalid synchronization schedule", "error", err)
+		os.Exit(EXIT_FAILURE)
+	}
 
-			defer func() {
-				ordersSync.Lock()
-				isOrdersSyncing = false
-				ordersSync.Unlock()
-			}()
+	if err := command.ValidateEntity(scheduler, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(EXIT_USAGE)
+	}
 
-			fmt.Println("Running scheduled orders synchronization...")
-			startTime = time.Now()
-			if err := SyncOrders(); err != nil {
-				log.Printf("Error synchronizing orders: %v", err)
-			} else {
-				elapsed := time.Since(startTime)
-				fmt.Printf("Orders synchronization completed successfully (elapsed time: %s)\n", elapsed)
-			}
-		}()
+	connections, err := database.Connect(context.Background(), cfg.MySQLURI, cfg.MongoDBURI, cfg.MongoDatabase(), cfg.Mongo.Collections)
+	if err != nil {
+		slog.Error("failed to connect to databases", "error", err)
+		os.Exit(EXIT_FAILURE)
+	}
+	deps.Connections = connections
 
-		go func() {
-			trackingSync.Lock()
-			if isTrackingSyncing {
-				fmt.Println("Orders tracking synchronization already in progress, skipping...")
-				trackingSync.Unlock()
-				return
-			}
-			isTrackingSyncing = true
-			trackingSync.Unlock()
+	scheduler.SetDryRun(options.DryRun || cfg.Sync.DryRun)
 
-			defer func() {
-				trackingSync.Lock()
-				isTrackingSyncing = false
-				trackingSync.Unlock()
-			}()
+	// The first SIGINT or SIGTERM cancels ctx and starts the graceful
+	// shutdown; a second one kills the process right away.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-			fmt.Println("Running scheduled orders tracking synchronization...")
-			startTime := time.Now()
-			if err := SyncOrdersTracking(); err != nil {
-				log.Printf("Error synchronizing orders tracking: %v", err)
-			} else {
-				elapsed := time.Since(startTime)
-				fmt.Printf("Orders tracking synchronization completed successfully (elapsed time: %s)\n", elapsed)
-			}
-		}()
-	}
+	code = command.Run(ctx, scheduler, args)
+	connections.Close(context.Background())
+	os.Exit(code)
 }
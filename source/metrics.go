@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database_sync/tiny"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const METRICS_NAMESPACE = "database_sync"
+
+var (
+	metricRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "runs_total",
+		Help:      "Sync job executions by outcome (succeeded, failed, skipped, blocked).",
+	}, []string{"entity", "outcome"})
+
+	metricRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of sync job executions.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 900, 1800, 3600, 7200},
+	}, []string{"entity"})
+
+	metricMySQLRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "mysql_rows_read_total",
+		Help:      "Rows read from MySQL.",
+	}, []string{"entity"})
+
+	metricMongoDocs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "mongo_documents_read_total",
+		Help:      "Documents read from MongoDB.",
+	}, []string{"entity"})
+
+	metricUpserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "upserts_total",
+		Help:      "Documents written to MongoDB, by result (inserted or updated).",
+	}, []string{"entity", "result"})
+
+	metricDeletes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "deletes_total",
+		Help:      "Documents deleted (or soft deleted) from MongoDB.",
+	}, []string{"entity"})
+
+	metricSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "skipped_total",
+		Help:      "Records left untouched because nothing changed.",
+	}, []string{"entity"})
+
+	metricFailedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "failed_records_total",
+		Help:      "Records that could not be synchronized during an otherwise running job.",
+	}, []string{"entity"})
+
+	metricBulkWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "bulk_writes_total",
+		Help:      "BulkWrite calls issued to MongoDB.",
+	}, []string{"entity"})
+
+	metricErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "errors_total",
+		Help:      "Sync job executions that ended with an error.",
+	}, []string{"entity"})
+
+	metricTinyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "tiny_api_requests_total",
+		Help:      "Calls to the Tiny API, retries included, by outcome.",
+	}, []string{"outcome"})
+
+	metricTinyWebhooks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "tiny_webhooks_total",
+		Help:      "Notifications posted by Tiny, by outcome (applied, duplicate, unknown_order, rejected, failed).",
+	}, []string{"outcome"})
+)
+
+func observeTinyRequest(outcome tiny.Outcome) {
+	metricTinyRequests.WithLabelValues(string(outcome)).Inc()
+}
+
+func observeRun(run *SyncRun, err error) {
+	entity := run.Entity
+
+	outcome := JobSucceeded
+	if err != nil {
+		outcome = JobFailed
+		metricErrors.WithLabelValues(entity).Inc()
+	}
+	metricRuns.WithLabelValues(entity, string(outcome)).Inc()
+	metricRunDuration.WithLabelValues(entity).Observe(time.Since(run.StartedAt).Seconds())
+
+	metricMySQLRows.WithLabelValues(entity).Add(float64(run.Stats.MySQLRows))
+	metricMongoDocs.WithLabelValues(entity).Add(float64(run.Stats.MongoDocs))
+	metricUpserts.WithLabelValues(entity, "inserted").Add(float64(run.Stats.Inserted))
+	metricUpserts.WithLabelValues(entity, "updated").Add(float64(run.Stats.Updated))
+	metricDeletes.WithLabelValues(entity).Add(float64(run.Stats.Deleted))
+	metricSkipped.WithLabelValues(entity).Add(float64(run.Stats.Skipped))
+	metricFailedRecords.WithLabelValues(entity).Add(float64(run.Stats.Failed))
+	metricBulkWrites.WithLabelValues(entity).Add(float64(run.Stats.BulkWrites))
+}
+
+func observeOutcome(entity string, outcome JobOutcome) {
+	metricRuns.WithLabelValues(entity, string(outcome)).Inc()
+}
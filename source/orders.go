@@ -3,19 +3,18 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database_sync/carriers"
+	"database_sync/config"
 	"database_sync/database"
-	"database_sync/utils"
-	"encoding/json"
+	"database_sync/tiny"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"strconv"
+	"sync"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type OrderStatus string
@@ -67,38 +66,85 @@ const (
 	TypeReposicao    OrderType = "Reposição"
 )
 
+// TinyOrder holds the Tiny ids from MySQL, then what the tracking job reads
+// from pedido.obter, so finance can reconcile Tiny against the budgets. The
+// amounts keep their zero values, so a zero discount reads back as written.
 type TinyOrder struct {
-	ID     string `json:"id,omitempty" bson:"id,omitempty"`
-	Number string `json:"number,omitempty" bson:"number,omitempty"`
+	ID            string          `json:"id,omitempty" bson:"id,omitempty"`
+	Number        string          `json:"number,omitempty" bson:"number,omitempty"`
+	Situacao      string          `json:"situacao,omitempty" bson:"situacao,omitempty"`
+	InvoiceID     string          `json:"invoice_id,omitempty" bson:"invoice_id,omitempty"`
+	InvoiceNumber string          `json:"invoice_number,omitempty" bson:"invoice_number,omitempty"`
+	Total         float64         `json:"total,omitempty" bson:"total"`
+	ProductsTotal float64         `json:"products_total,omitempty" bson:"products_total"`
+	Shipping      float64         `json:"shipping,omitempty" bson:"shipping"`
+	Discount      float64         `json:"discount,omitempty" bson:"discount"`
+	Items         []TinyOrderItem `json:"items,omitempty" bson:"items"`
 }
 
+type TinyOrderItem struct {
+	ProductID   string  `json:"product_id,omitempty" bson:"product_id,omitempty"`
+	Code        string  `json:"code,omitempty" bson:"code,omitempty"`
+	Description string  `json:"description,omitempty" bson:"description,omitempty"`
+	Unit        string  `json:"unit,omitempty" bson:"unit,omitempty"`
+	Quantity    float64 `json:"quantity" bson:"quantity"`
+	UnitPrice   float64 `json:"unit_price" bson:"unit_price"`
+}
+
+// Tracking holds the shipping service, url and code from Tiny, then the
+// events the carrier reported for the code, oldest first.
 type Tracking struct {
-	Service string `json:"service,omitempty" bson:"service,omitempty"`
-	Url     string `json:"url,omitempty" bson:"url,omitempty"`
-	Code    string `json:"code,omitempty" bson:"code,omitempty"`
+	Service     string           `json:"service,omitempty" bson:"service,omitempty"`
+	Url         string           `json:"url,omitempty" bson:"url,omitempty"`
+	Code        string           `json:"code,omitempty" bson:"code,omitempty"`
+	Carrier     string           `json:"carrier,omitempty" bson:"carrier,omitempty"`
+	Events      []carriers.Event `json:"events,omitempty" bson:"events,omitempty"`
+	LastStatus  string           `json:"last_status,omitempty" bson:"last_status,omitempty"`
+	LastEventAt *time.Time       `json:"last_event_at,omitempty" bson:"last_event_at,omitempty"`
+	DeliveredAt *time.Time       `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+
+	// Attempts counts the checks in Tiny in a row that brought nothing new.
+	// StoppedAt is set once they reach tracking.max_attempts, after which
+	// the tracking job no longer reads the order.
+	Attempts      int        `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	LastCheckedAt *time.Time `json:"last_checked_at,omitempty" bson:"last_checked_at,omitempty"`
+	NextCheckAt   *time.Time `json:"next_check_at,omitempty" bson:"next_check_at,omitempty"`
+	StoppedAt     *time.Time `json:"stopped_at,omitempty" bson:"stopped_at,omitempty"`
+
+	// The same bookkeeping for the lookups of the code with its carrier,
+	// bounded by carriers.max_attempts.
+	CarrierAttempts      int        `json:"carrier_attempts,omitempty" bson:"carrier_attempts,omitempty"`
+	CarrierLastCheckedAt *time.Time `json:"carrier_last_checked_at,omitempty" bson:"carrier_last_checked_at,omitempty"`
+	CarrierNextCheckAt   *time.Time `json:"carrier_next_check_at,omitempty" bson:"carrier_next_check_at,omitempty"`
+	CarrierStoppedAt     *time.Time `json:"carrier_stopped_at,omitempty" bson:"carrier_stopped_at,omitempty"`
 }
 
 type MongoDBOrders struct {
-	ID                 bson.ObjectID `json:"_id,omitempty" bson:"_id,omitempty"`
-	OldID              uint64        `json:"old_id" bson:"old_id"`
-	CreatedBy          bson.ObjectID `json:"created_by,omitempty" bson:"created_by,omitempty"`
-	RelatedSeller      bson.ObjectID `json:"related_seller,omitempty" bson:"related_seller,omitempty"`
-	RelatedDesigner    bson.ObjectID `json:"related_designer,omitempty" bson:"related_designer,omitempty"`
-	TrackingCode       string        `json:"tracking_code,omitempty" bson:"tracking_code,omitempty"`
-	Status             OrderStatus   `json:"status,omitempty" bson:"status,omitempty"`
-	Stage              OrderStage    `json:"stage,omitempty" bson:"stage,omitempty"`
-	Type               OrderType     `json:"type,omitempty" bson:"type,omitempty"`
-	UrlTrello          string        `json:"url_trello,omitempty" bson:"url_trello,omitempty"`
-	ProductsListLegacy string        `json:"products_list_legacy,omitempty" bson:"products_list_legacy,omitempty"`
-	RelatedBudget      bson.ObjectID `json:"related_budget,omitempty" bson:"related_budget,omitempty"`
-	ExpectedDate       time.Time     `json:"expected_date,omitempty" bson:"expected_date,omitempty"`
-	CustomProperties   any           `json:"custom_properties,omitempty" bson:"custom_properties,omitempty"`
-	Tiny               TinyOrder     `json:"tiny,omitempty" bson:"tiny,omitempty"`
-	Notes              string        `json:"notes,omitempty" bson:"notes,omitempty"`
-	PaymentDate        *time.Time    `json:"payment_date,omitempty" bson:"payment_date,omitempty"`
-	CreatedAt          time.Time     `json:"created_at" bson:"created_at"`
-	UpdatedAt          time.Time     `json:"updated_at" bson:"updated_at"`
-	Tracking           Tracking      `json:"tracking" bson:"tracking"`
+	ID                  bson.ObjectID        `json:"_id,omitempty" bson:"_id,omitempty"`
+	OldID               uint64               `json:"old_id" bson:"old_id"`
+	CreatedBy           bson.ObjectID        `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	RelatedSeller       bson.ObjectID        `json:"related_seller,omitempty" bson:"related_seller,omitempty"`
+	RelatedDesigner     bson.ObjectID        `json:"related_designer,omitempty" bson:"related_designer,omitempty"`
+	TrackingCode        string               `json:"tracking_code,omitempty" bson:"tracking_code,omitempty"`
+	Status              OrderStatus          `json:"status,omitempty" bson:"status,omitempty"`
+	Stage               OrderStage           `json:"stage,omitempty" bson:"stage,omitempty"`
+	Type                OrderType            `json:"type,omitempty" bson:"type,omitempty"`
+	UrlTrello           string               `json:"url_trello,omitempty" bson:"url_trello,omitempty"`
+	ProductsListLegacy  string               `json:"products_list_legacy,omitempty" bson:"products_list_legacy,omitempty"`
+	Products            []MongoDBProductLine `json:"products,omitempty" bson:"products,omitempty"`
+	ProductsParseErrors []string             `json:"products_parse_errors,omitempty" bson:"products_parse_errors,omitempty"`
+	RelatedBudget       bson.ObjectID        `json:"related_budget,omitempty" bson:"related_budget,omitempty"`
+	ExpectedDate        time.Time            `json:"expected_date,omitempty" bson:"expected_date,omitempty"`
+	CustomProperties    any                  `json:"custom_properties,omitempty" bson:"custom_properties,omitempty"`
+	Tiny                TinyOrder            `json:"tiny,omitempty" bson:"tiny,omitempty"`
+	Notes               string               `json:"notes,omitempty" bson:"notes,omitempty"`
+	PaymentDate         *time.Time           `json:"payment_date,omitempty" bson:"payment_date,omitempty"`
+	CreatedAt           time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time            `json:"updated_at" bson:"updated_at"`
+	Tracking            Tracking             `json:"tracking" bson:"tracking"`
+	SyncHash            string               `json:"sync_hash,omitempty" bson:"sync_hash,omitempty"`
+	DeletedAt           *time.Time           `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBySync       bool                 `json:"deleted_by_sync,omitempty" bson:"deleted_by_sync,omitempty"`
 }
 
 type MySQLOrders struct {
@@ -174,37 +220,24 @@ var letraToOrderStage = map[string]OrderStage{
 	"F": StageConferencia,
 }
 
-func SyncOrders() error {
-	mysqlURI := os.Getenv("MYSQL_URI")
+func SyncOrders(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	mysqlDB := deps.Connections.MySQL()
 
-	mysqlDB, err := sql.Open("mysql", mysqlURI)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
-	}
-	defer mysqlDB.Close()
+	ctx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
+	defer cancel()
 
-	mysqlDB.SetConnMaxLifetime(database.MYSQL_CONN_MAX_LIFETIME)
-	mysqlDB.SetMaxOpenConns(database.MYSQL_MAX_OPEN_CONNS)
-	mysqlDB.SetMaxIdleConns(database.MYSQL_MAX_IDLE_CONNS)
+	mongoDB := deps.Connections.MongoDB()
 
-	if err := mysqlDB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping MySQL: %w", err)
-	}
+	ordersCollection := mongoDB.Collection(database.COLLECTION_ORDERS)
+	usersCollection := mongoDB.Collection(database.COLLECTION_USERS)
+	budgetsCollection := mongoDB.Collection(database.COLLECTION_BUDGETS)
 
-	ctx, cancel := context.WithTimeout(context.Background(), database.MONGODB_TIMEOUT)
-	defer cancel()
-
-	mongoURI := os.Getenv(utils.MONGODB_URI)
-	opts := options.Client().ApplyURI(mongoURI)
-	mongoClient, err := mongo.Connect(opts)
+	state, err := LoadSyncState(ctx, mongoDB, database.COLLECTION_ORDERS)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		return err
 	}
-	defer mongoClient.Disconnect(ctx)
-
-	ordersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_ORDERS)
-	usersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_USERS)
-	budgetsCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_BUDGETS)
+	startedAt := time.Now()
+	fullSync := run.ForceFullSync || state.NeedsFullSync(startedAt, deps.Config.Sync.FullReconciliationInterval)
 
 	userOldIDToObjectID := make(map[uint64]bson.ObjectID)
 	userCursor, err := usersCollection.Find(ctx, bson.D{})
@@ -238,9 +271,23 @@ func SyncOrders() error {
 	}
 	budgetCursor.Close(ctx)
 
+	productsCollection := mongoDB.Collection(database.COLLECTION_PRODUCTS)
+	products, err := loadProductRefs(ctx, productsCollection)
+	if err != nil {
+		return err
+	}
+
 	allOrdersMap := make(map[uint64]*MySQLOrders)
 
-	dataRows, err := mysqlDB.Query(`SELECT id, user_id, numero_pedido, prazo_arte_final, prazo_confeccao, lista_produtos, observacoes, rolo, pedido_status_id, pedido_tipo_id, estagio, url_trello, situacao, prioridade, orcamento_id, created_at, updated_at, tiny_pedido_id, data_prevista, vendedor_id, designer_id, codigo_rastreamento, data_pagamento FROM pedidos_arte_final WHERE id IS NOT NULL`)
+	query := `SELECT id, user_id, numero_pedido, prazo_arte_final, prazo_confeccao, lista_produtos, observacoes, rolo, pedido_status_id, pedido_tipo_id, estagio, url_trello, situacao, prioridade, orcamento_id, created_at, updated_at, tiny_pedido_id, data_prevista, vendedor_id, designer_id, codigo_rastreamento, data_pagamento FROM pedidos_arte_final WHERE id IS NOT NULL`
+	var args []any
+	if !fullSync {
+		condition, conditionArgs := state.WhereChanged("updated_at", "id")
+		query += " AND " + condition
+		args = conditionArgs
+	}
+
+	dataRows, err := mysqlDB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to query MySQL orders data: %w", err)
 	}
@@ -278,6 +325,11 @@ func SyncOrders() error {
 		}
 		if order.ID.Valid {
 			allOrdersMap[uint64(order.ID.Int64)] = order
+			if order.UpdatedAt.Valid {
+				if t, err := time.Parse("2006-01-02 15:04:05", order.UpdatedAt.String); err == nil {
+					state.Advance(t, strconv.FormatInt(order.ID.Int64, 10))
+				}
+			}
 		}
 	}
 	dataRows.Close()
@@ -285,6 +337,7 @@ func SyncOrders() error {
 	if err = dataRows.Err(); err != nil {
 		return fmt.Errorf("error iterating MySQL data rows: %w", err)
 	}
+	run.Stats.MySQLRows = len(allOrdersMap)
 
 	if len(allOrdersMap) == 0 {
 		return nil
@@ -298,7 +351,16 @@ func SyncOrders() error {
 	mongoIDs := make(map[uint64]bool)
 	mongoOrdersData := make(map[uint64]MongoDBOrders)
 
-	cursor, err := ordersCollection.Find(ctx, bson.D{})
+	mongoFilter := bson.D{}
+	if !fullSync {
+		changedIDs := make([]uint64, 0, len(mysqlIDs))
+		for id := range mysqlIDs {
+			changedIDs = append(changedIDs, id)
+		}
+		mongoFilter = bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: changedIDs}}}}
+	}
+
+	cursor, err := ordersCollection.Find(ctx, mongoFilter)
 	if err != nil {
 		return fmt.Errorf("failed to query MongoDB orders: %w", err)
 	}
@@ -309,8 +371,11 @@ func SyncOrders() error {
 		if err := cursor.Decode(&order); err != nil {
 			return fmt.Errorf("failed to decode MongoDB order: %w", err)
 		}
+		run.Stats.MongoDocs++
 		if order.OldID > 0 {
-			mongoIDs[order.OldID] = true
+			if order.DeletedAt == nil {
+				mongoIDs[order.OldID] = true
+			}
 			mongoOrdersData[order.OldID] = order
 		}
 	}
@@ -320,19 +385,19 @@ func SyncOrders() error {
 	}
 
 	idsToDelete := []uint64{}
-	for mongoID := range mongoIDs {
-		if !mysqlIDs[mongoID] {
-			idsToDelete = append(idsToDelete, mongoID)
+	if fullSync {
+		for mongoID := range mongoIDs {
+			if !mysqlIDs[mongoID] {
+				idsToDelete = append(idsToDelete, mongoID)
+			}
 		}
 	}
 
-	if len(idsToDelete) > 0 {
-		deleteFilter := bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: idsToDelete}}}}
-		_, err := ordersCollection.DeleteMany(ctx, deleteFilter)
-		if err != nil {
-			return fmt.Errorf("failed to delete non-existing orders from MongoDB: %w", err)
-		}
+	deleted, err := reconcileDeletes(ctx, run, ordersCollection, "old_id", idsToDelete, len(mongoIDs))
+	if err != nil {
+		return err
 	}
+	run.Stats.Deleted = deleted
 
 	idsToUpsert := []uint64{}
 	for id := range allOrdersMap {
@@ -340,17 +405,15 @@ func SyncOrders() error {
 	}
 
 	if len(idsToUpsert) == 0 {
-		return nil
+		return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state)
 	}
 
-	batchSize := 50
-	if len(idsToUpsert) > 1000 {
-		batchSize = 200
-	} else if len(idsToUpsert) > 5000 {
-		batchSize = 500
-	}
+	batchSize := deps.Config.Sync.BatchSizeFor(len(idsToUpsert))
 
 	bulkOperations := []mongo.WriteModel{}
+	processedCount := 0
+	skippedCount := 0
+	unparseableCount := 0
 
 	for _, id := range idsToUpsert {
 		order := allOrdersMap[id]
@@ -386,6 +449,11 @@ func SyncOrders() error {
 
 		if order.PedidoStatusID.Valid {
 			if status, ok := statusIDToOrderStatus[uint64(order.PedidoStatusID.Int64)]; ok {
+				// A delivery the carrier confirmed is not undone by a row
+				// nobody moved past "Em entrega", only by a return.
+				if deps.Config.Carriers.AutoDeliver && mongoOrdersData[id].Tracking.DeliveredAt != nil && status != StatusDevolucao {
+					status = StatusEntregue
+				}
 				mongoOrder = append(mongoOrder, bson.E{Key: "status", Value: status})
 			}
 		}
@@ -408,6 +476,15 @@ func SyncOrders() error {
 
 		if order.ListaProdutos.Valid {
 			mongoOrder = append(mongoOrder, bson.E{Key: "products_list_legacy", Value: order.ListaProdutos.String})
+
+			lines, issues := ParseLegacyProductsList(order.ListaProdutos.String)
+			mongoOrder = append(mongoOrder,
+				bson.E{Key: "products", Value: products.resolve(lines)},
+				bson.E{Key: "products_parse_errors", Value: issues},
+			)
+			if len(issues) > 0 {
+				unparseableCount++
+			}
 		}
 
 		if order.PrazoArteFinal.Valid {
@@ -442,16 +519,11 @@ func SyncOrders() error {
 			}
 		}
 
+		// Dotted keys keep the order data the tracking job reads from Tiny.
 		if order.TinyPedidoID.Valid {
-			tinyField := bson.M{}
-			if order.TinyPedidoID.Valid {
-				tinyField["id"] = order.TinyPedidoID.String
-			}
+			mongoOrder = append(mongoOrder, bson.E{Key: "tiny.id", Value: order.TinyPedidoID.String})
 			if order.NumeroPedido.Valid {
-				tinyField["number"] = order.NumeroPedido.String
-			}
-			if len(tinyField) > 0 {
-				mongoOrder = append(mongoOrder, bson.E{Key: "tiny", Value: tinyField})
+				mongoOrder = append(mongoOrder, bson.E{Key: "tiny.number", Value: order.NumeroPedido.String})
 			}
 		}
 
@@ -469,8 +541,19 @@ func SyncOrders() error {
 			}
 		}
 
+		hash, err := documentHash(mongoOrder)
+		if err != nil {
+			return fmt.Errorf("failed to hash order %d: %w", id, err)
+		}
+		if existing, exists := mongoOrdersData[id]; exists && existing.SyncHash == hash && !existing.DeletedBySync {
+			skippedCount++
+			continue
+		}
+		mongoOrder = append(mongoOrder, bson.E{Key: "sync_hash", Value: hash})
+
 		filter := bson.D{{Key: "old_id", Value: id}}
-		update := bson.D{{Key: "$set", Value: mongoOrder}}
+		update := upsertUpdate(mongoOrder, mongoOrdersData[id].DeletedBySync)
+		run.Changes.Upsert(id, snapshotOf(mongoOrdersData, id), mongoOrder)
 
 		upsertModel := mongo.NewUpdateOneModel().
 			SetFilter(filter).
@@ -478,9 +561,10 @@ func SyncOrders() error {
 			SetUpsert(true)
 
 		bulkOperations = append(bulkOperations, upsertModel)
+		processedCount++
 
 		if len(bulkOperations) >= batchSize {
-			if _, err := ordersCollection.BulkWrite(ctx, bulkOperations); err != nil {
+			if err := run.BulkWrite(ctx, ordersCollection, bulkOperations); err != nil {
 				return fmt.Errorf("failed to execute bulk write: %w", err)
 			}
 			bulkOperations = []mongo.WriteModel{}
@@ -488,72 +572,86 @@ func SyncOrders() error {
 	}
 
 	if len(bulkOperations) > 0 {
-		if _, err := ordersCollection.BulkWrite(ctx, bulkOperations); err != nil {
+		if err := run.BulkWrite(ctx, ordersCollection, bulkOperations); err != nil {
 			return fmt.Errorf("failed to execute final bulk write: %w", err)
 		}
 	}
 
-	return nil
-}
+	run.Stats.Skipped = skippedCount
 
-type TinyAPIResponse struct {
-	Retorno struct {
-		StatusProcessamento interface{} `json:"status_processamento"`
-		Status              string      `json:"status"`
-		Pedido              struct {
-			FormaFrete         string `json:"forma_frete"`
-			CodigoRastreamento string `json:"codigo_rastreamento"`
-			URLRastreamento    string `json:"url_rastreamento"`
-		} `json:"pedido"`
-	} `json:"retorno"`
-}
-
-func SyncOrdersTracking() error {
-	ctx, cancel := context.WithTimeout(context.Background(), database.MONGODB_TIMEOUT)
-	defer cancel()
+	run.Logger.Info("orders written", "upserted", processedCount, "unchanged", skippedCount)
 
-	mongoURI := os.Getenv(utils.MONGODB_URI)
-	opts := options.Client().ApplyURI(mongoURI)
-	mongoClient, err := mongo.Connect(opts)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	if unparseableCount > 0 {
+		run.Logger.Warn("orders have product rows that could not be parsed, see products_parse_errors", "count", unparseableCount)
 	}
-	defer mongoClient.Disconnect(ctx)
 
-	ordersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_ORDERS)
+	return run.CommitSyncState(ctx, mongoDB, fullSync, startedAt, state)
+}
 
-	fiveMonthsAgo := time.Now().AddDate(0, -2, 0)
+// tinyFinalSituations are the Tiny situações after which an order no longer
+// changes in Tiny.
+var tinyFinalSituations = []string{"Entregue", "Cancelado"}
+
+// untrackedOrderStatuses are the order statuses after which the tracking job
+// no longer reads an order from Tiny.
+var untrackedOrderStatuses = []OrderStatus{StatusEntregue, StatusRetirada}
+
+// SyncOrdersTracking reads recent orders from Tiny's pedido.obter, filling
+// their tracking and the Tiny order data: situação, totals, item lines and
+// the invoice number. Orders are read again until Tiny gave them a tracking
+// code and their situação is final, with the backoff and the attempt limit of
+// tracking config. With the Tiny webhook on, this is the sweep catching the
+// notifications that were lost.
+func SyncOrdersTracking(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	queryCtx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
+	defer cancel()
+
+	ordersCollection := deps.Connections.MongoDB().Collection(database.COLLECTION_ORDERS)
+
+	now := time.Now()
+	since := now.Add(-deps.Config.Tracking.Lookback)
+
+	due := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "tracking.next_check_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "tracking.next_check_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+	}}}
+	incomplete := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "tracking", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{{Key: "tracking.service", Value: bson.D{{Key: "$exists", Value: false}}}},
+		bson.D{
+			{Key: "tracking.service", Value: bson.D{{Key: "$ne", Value: "Retirar Pessoalmente"}}},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "tracking.url", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "tracking.url", Value: ""}},
+				bson.D{{Key: "tracking.code", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "tracking.code", Value: ""}},
+			}},
+		},
+		bson.D{{Key: "tiny.situacao", Value: bson.D{{Key: "$nin", Value: tinyFinalSituations}}}},
+	}}}
 
 	filter := bson.D{
-		{Key: "created_at", Value: bson.D{{Key: "$gte", Value: fiveMonthsAgo}}},
-		{Key: "$or", Value: bson.A{
-			bson.D{{Key: "tracking", Value: bson.D{{Key: "$exists", Value: false}}}},
-			bson.D{{Key: "tracking.service", Value: bson.D{{Key: "$exists", Value: false}}}},
-			bson.D{
-				{Key: "tracking.service", Value: bson.D{{Key: "$ne", Value: "Retirar Pessoalmente"}}},
-				{Key: "$or", Value: bson.A{
-					bson.D{{Key: "tracking.url", Value: bson.D{{Key: "$exists", Value: false}}}},
-					bson.D{{Key: "tracking.url", Value: ""}},
-					bson.D{{Key: "tracking.code", Value: bson.D{{Key: "$exists", Value: false}}}},
-					bson.D{{Key: "tracking.code", Value: ""}},
-				}},
-			},
-		}},
+		{Key: "created_at", Value: bson.D{{Key: "$gte", Value: since}}},
+		{Key: "$and", Value: bson.A{due, incomplete}},
+		{Key: "status", Value: bson.D{{Key: "$nin", Value: untrackedOrderStatuses}}},
+		{Key: "tracking.stopped_at", Value: bson.D{{Key: "$exists", Value: false}}},
 		{Key: "tiny.id", Value: bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: ""}}},
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
 	}
 
-	cursor, err := ordersCollection.Find(ctx, filter)
+	cursor, err := ordersCollection.Find(queryCtx, filter)
 	if err != nil {
 		return fmt.Errorf("failed to query MongoDB orders: %w", err)
 	}
-	defer cursor.Close(ctx)
+	defer cursor.Close(queryCtx)
 
 	var ordersToProcess []MongoDBOrders
-	for cursor.Next(ctx) {
+	for cursor.Next(queryCtx) {
 		var order MongoDBOrders
 		if err := cursor.Decode(&order); err != nil {
 			return fmt.Errorf("failed to decode MongoDB order: %w", err)
 		}
+		run.Stats.MongoDocs++
 
 		if order.Tiny.ID != "" {
 			ordersToProcess = append(ordersToProcess, order)
@@ -564,78 +662,211 @@ func SyncOrdersTracking() error {
 		return fmt.Errorf("error iterating MongoDB cursor: %w", err)
 	}
 
-	cursor.Close(ctx)
+	cursor.Close(queryCtx)
 	cancel()
 
-	tinyToken := os.Getenv("TINY_TOKEN")
-	client := &http.Client{}
-
-	for _, order := range ordersToProcess {
-		time.Sleep(20 * time.Second)
+	// Workers only call Tiny. The results are applied here, one at a time,
+	// since the run stats and the change log are not safe for concurrent use.
+	fetchCtx, stopFetching := context.WithCancel(ctx)
+	defer stopFetching()
+
+	pending := make(chan MongoDBOrders)
+	results := make(chan trackingLookup)
+
+	go func() {
+		defer close(pending)
+		for _, order := range ordersToProcess {
+			select {
+			case pending <- order:
+			case <-fetchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for range deps.Config.Tiny.Workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for order := range pending {
+				lookup := trackingLookup{order: order}
+				lookup.tinyOrder, lookup.err = deps.Tiny.GetOrder(fetchCtx, order.Tiny.ID)
+
+				// The invoice number is read once, when the order is invoiced.
+				if lookup.err == nil && lookup.tinyOrder.Invoiced() &&
+					(order.Tiny.InvoiceID != lookup.tinyOrder.IDNotaFiscal || order.Tiny.InvoiceNumber == "") {
+					lookup.invoice, lookup.err = deps.Tiny.GetInvoice(fetchCtx, lookup.tinyOrder.IDNotaFiscal)
+				}
+				results <- lookup
+			}
+		}()
+	}
 
-		url := fmt.Sprintf("https://api.tiny.com.br/api2/pedido.obter.php?token=%s&formato=json&id=%s", tinyToken, order.Tiny.ID)
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
 
-		resp, err := client.Get(url)
-		if err != nil {
-			fmt.Printf("Failed to fetch tracking info for order %s (tiny_id: %s): %v\n", order.ID.Hex(), order.Tiny.ID, err)
+	var abortErr error
+	for result := range results {
+		if abortErr != nil || ctx.Err() != nil {
 			continue
 		}
 
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			fmt.Printf("Failed to read response body for order %s (tiny_id: %s): %v\n", order.ID.Hex(), order.Tiny.ID, err)
+		order := result.order
+		logger := run.Logger.With("order_id", order.ID.Hex(), "tiny_id", order.Tiny.ID)
+
+		// An order Tiny does not know counts as a check that brought
+		// nothing, other failures are not the order's fault.
+		var set bson.D
+		switch {
+		case errors.Is(result.err, tiny.ErrUnauthorized):
+			// Every other lookup would fail the same way.
+			abortErr = fmt.Errorf("failed to fetch tracking info: %w", result.err)
+			stopFetching()
 			continue
+		case errors.Is(result.err, tiny.ErrNotFound):
+			logger.Debug("order not found in Tiny")
+		case result.err != nil:
+			logger.Warn("failed to fetch tracking info", "error", result.err)
+			run.RecordFailure(order.ID.Hex())
+			continue
+		default:
+			set = tinyOrderUpdate(order, result.tinyOrder, result.invoice)
+			changed, err := changesAnything(order, set)
+			if err != nil {
+				logger.Warn("failed to compare the order with Tiny", "error", err)
+				run.RecordFailure(order.ID.Hex())
+				continue
+			}
+			if !changed {
+				set = nil
+			}
 		}
 
-		var tinyResponse TinyAPIResponse
-		if err := json.Unmarshal(body, &tinyResponse); err != nil {
-			fmt.Printf("Failed to parse JSON response for order %s (tiny_id: %s): %v\n", order.ID.Hex(), order.Tiny.ID, err)
-			fmt.Printf("Raw JSON response: %s\n", string(body))
+		check, stopped := trackingCheck(deps.Config.Tracking, order, set, time.Now())
+		updateFilter := bson.D{{Key: "_id", Value: order.ID}}
+		update := bson.D{{Key: "$set", Value: append(set, check...)}}
+		if setsNewTrackingCode(order.Tracking.Code, set) {
+			update = append(update, bson.E{Key: "$unset", Value: carrierCheckFields})
+		}
+
+		if run.DryRun {
+			run.Report.planUpserts([]mongo.WriteModel{mongo.NewUpdateOneModel().SetFilter(updateFilter).SetUpdate(update)})
 			continue
 		}
 
-		if tinyResponse.Retorno.Status != "OK" {
-			fmt.Printf("Tiny API returned error for order %s (tiny_id: %s): %s\n", order.ID.Hex(), order.Tiny.ID, tinyResponse.Retorno.Status)
+		// The update is not canceled on shutdown, it is short and the Tiny
+		// calls it depends on were already paid for.
+		updateCtx, updateCancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		_, err := ordersCollection.UpdateOne(updateCtx, updateFilter, update)
+		if err != nil {
+			updateCancel()
+			logger.Warn("failed to update tracking", "error", err)
+			run.RecordFailure(order.ID.Hex())
 			continue
 		}
 
-		pedido := tinyResponse.Retorno.Pedido
+		if stopped {
+			logger.Info("no news from Tiny after the maximum number of checks, no longer tracking the order", "attempts", deps.Config.Tracking.MaxAttempts)
+		}
+		if len(set) == 0 {
+			run.Stats.Skipped++
+			updateCancel()
+			continue
+		}
 
-		if pedido.FormaFrete != "" {
-			tracking := Tracking{
-				Service: pedido.FormaFrete,
-				Url:     pedido.URLRastreamento,
-				Code:    pedido.CodigoRastreamento,
-			}
+		run.Stats.Updated++
+		run.Changes.Upsert(order.OldID, order, set)
+		run.Changes.Flush(updateCtx)
+		updateCancel()
+		logger.Debug("updated tracking and Tiny data")
+	}
 
-			updateCtx, updateCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if abortErr != nil {
+		return abortErr
+	}
+	return ctx.Err()
+}
 
-			mongoClient, err := mongo.Connect(options.Client().ApplyURI(os.Getenv(utils.MONGODB_URI)))
-			if err != nil {
-				updateCancel()
-				fmt.Printf("Failed to reconnect to MongoDB for order %s: %v\n", order.ID.Hex(), err)
-				continue
-			}
+// trackingLookup is the answer of Tiny for one order of the tracking job.
+type trackingLookup struct {
+	order     MongoDBOrders
+	tinyOrder *tiny.Order
+	invoice   *tiny.Invoice
+	err       error
+}
 
-			ordersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_ORDERS)
+// trackingCheck returns the bookkeeping of a check of order, set being what
+// the check changed. A check that changed something resets the attempts, the
+// others push the next check back and, after MaxAttempts in a row, stop the
+// checks. The bookkeeping stays out of the change log.
+func trackingCheck(cfg config.TrackingConfig, order MongoDBOrders, set bson.D, now time.Time) (bson.D, bool) {
+	return backoffCheck("tracking.", cfg.BackoffConfig, order.Tracking.Attempts, len(set) > 0, now)
+}
 
-			updateFilter := bson.D{{Key: "_id", Value: order.ID}}
-			update := bson.D{{Key: "$set", Value: bson.D{{Key: "tracking", Value: tracking}}}}
+// backoffCheck returns the bookkeeping fields, named prefix followed by
+// attempts, last_checked_at, next_check_at and stopped_at, of a check that
+// follows attempts checks in a row without news, and whether it stops them.
+func backoffCheck(prefix string, cfg config.BackoffConfig, attempts int, changed bool, now time.Time) (bson.D, bool) {
+	attempts++
+	if changed {
+		attempts = 0
+	}
 
-			_, err = ordersCollection.UpdateOne(updateCtx, updateFilter, update)
+	check := bson.D{
+		{Key: prefix + "attempts", Value: attempts},
+		{Key: prefix + "last_checked_at", Value: now},
+	}
+	if attempts >= cfg.MaxAttempts {
+		return append(check, bson.E{Key: prefix + "stopped_at", Value: now}), true
+	}
+	return append(check, bson.E{Key: prefix + "next_check_at", Value: now.Add(cfg.NextCheck(attempts))}), false
+}
 
-			mongoClient.Disconnect(updateCtx)
-			updateCancel()
+// tinyOrderUpdate returns the fields to $set from the Tiny order. Dotted keys
+// leave the carrier events of tracking and the ids of tiny untouched. invoice
+// is nil when the number stored for the invoice is still current.
+func tinyOrderUpdate(order MongoDBOrders, tinyOrder *tiny.Order, invoice *tiny.Invoice) bson.D {
+	var set bson.D
+	// Tiny gives the shipping service before the code and the url. Empty
+	// values are left out, the stored order has no such fields to match them.
+	if tinyOrder.FormaFrete != "" {
+		set = append(set, bson.E{Key: "tracking.service", Value: tinyOrder.FormaFrete})
+		if tinyOrder.URLRastreamento != "" {
+			set = append(set, bson.E{Key: "tracking.url", Value: tinyOrder.URLRastreamento})
+		}
+		if tinyOrder.CodigoRastreamento != "" {
+			set = append(set, bson.E{Key: "tracking.code", Value: tinyOrder.CodigoRastreamento})
+		}
+	}
 
-			if err != nil {
-				fmt.Printf("Failed to update tracking for order %s: %v\n", order.ID.Hex(), err)
-				continue
-			}
+	var items []TinyOrderItem
+	for _, item := range tinyOrder.Items() {
+		items = append(items, TinyOrderItem{
+			ProductID:   item.IDProduto,
+			Code:        item.Codigo,
+			Description: item.Descricao,
+			Unit:        item.Unidade,
+			Quantity:    float64(item.Quantidade),
+			UnitPrice:   float64(item.ValorUnitario),
+		})
+	}
 
-			fmt.Printf("Updated tracking for order %s (tiny_id: %s)\n", order.ID.Hex(), order.Tiny.ID)
+	set = append(set,
+		bson.E{Key: "tiny.situacao", Value: tinyOrder.Situacao},
+		bson.E{Key: "tiny.total", Value: float64(tinyOrder.TotalPedido)},
+		bson.E{Key: "tiny.products_total", Value: float64(tinyOrder.TotalProdutos)},
+		bson.E{Key: "tiny.shipping", Value: float64(tinyOrder.ValorFrete)},
+		bson.E{Key: "tiny.discount", Value: float64(tinyOrder.ValorDesconto)},
+		bson.E{Key: "tiny.items", Value: items},
+	)
+	if tinyOrder.Invoiced() {
+		set = append(set, bson.E{Key: "tiny.invoice_id", Value: tinyOrder.IDNotaFiscal})
+		if invoice != nil {
+			set = append(set, bson.E{Key: "tiny.invoice_number", Value: invoice.Numero})
 		}
 	}
-
-	return nil
+	return set
 }
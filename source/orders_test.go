@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database_sync/config"
+	"database_sync/tiny"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// pollTiny runs the part of the tracking job that decides what one check of
+// order writes, and returns the fields it changes and its bookkeeping.
+func pollTiny(t *testing.T, cfg config.TrackingConfig, order MongoDBOrders, tinyOrder *tiny.Order, now time.Time) (bson.D, bson.D, bool) {
+	t.Helper()
+	set := tinyOrderUpdate(order, tinyOrder, nil)
+	changed, err := changesAnything(order, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		set = nil
+	}
+	check, stopped := trackingCheck(cfg, order, set, now)
+	return set, check, stopped
+}
+
+func TestTrackingStopsWithoutCode(t *testing.T) {
+	cfg := config.Default().Tracking
+	cfg.MaxAttempts = 4
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Tiny knows the shipping service, the code and url come later.
+	tinyOrder := &tiny.Order{ID: "123", Situacao: "Aprovado", TotalPedido: 150, TotalProdutos: 150, FormaFrete: "Correios SEDEX"}
+	order := MongoDBOrders{Tiny: TinyOrder{ID: "123"}}
+
+	set, _, _ := pollTiny(t, cfg, order, tinyOrder, now)
+	for _, field := range set {
+		if field.Key == "tracking.code" || field.Key == "tracking.url" {
+			t.Errorf("%s set to %q, want it left out", field.Key, field.Value)
+		}
+	}
+
+	// The order as the first check stored it.
+	order.Tracking.Service = "Correios SEDEX"
+	order.Tiny = TinyOrder{ID: "123", Situacao: "Aprovado", Total: 150, ProductsTotal: 150}
+
+	var lastDelay time.Duration
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		set, check, stopped := pollTiny(t, cfg, order, tinyOrder, now)
+		if len(set) > 0 {
+			t.Fatalf("check %d changes %v, want nothing", attempt, set)
+		}
+		if got := lookup(check, "tracking.attempts"); got != attempt {
+			t.Fatalf("check %d: attempts = %v, want %d", attempt, got, attempt)
+		}
+		order.Tracking.Attempts = attempt
+
+		if attempt < cfg.MaxAttempts {
+			if stopped {
+				t.Fatalf("stopped after %d checks, want %d", attempt, cfg.MaxAttempts)
+			}
+			delay := lookup(check, "tracking.next_check_at").(time.Time).Sub(now)
+			if delay < lastDelay {
+				t.Errorf("check %d: next check in %v, want at least %v", attempt, delay, lastDelay)
+			}
+			lastDelay = delay
+			continue
+		}
+		if !stopped || lookup(check, "tracking.stopped_at") == nil {
+			t.Errorf("check %d = %v, want the order stopped", attempt, check)
+		}
+	}
+}
+
+func TestTrackingCheckResetsOnChange(t *testing.T) {
+	cfg := config.Default().Tracking
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tinyOrder := &tiny.Order{ID: "123", Situacao: "Enviado", FormaFrete: "Correios SEDEX", CodigoRastreamento: "QB123456789BR"}
+	order := MongoDBOrders{Tiny: TinyOrder{ID: "123", Situacao: "Aprovado"}, Tracking: Tracking{Service: "Correios SEDEX", Attempts: 7}}
+
+	set, check, stopped := pollTiny(t, cfg, order, tinyOrder, now)
+	if lookup(set, "tracking.code") != "QB123456789BR" || lookup(set, "tiny.situacao") != "Enviado" {
+		t.Errorf("set = %v, want the new code and situação", set)
+	}
+	if stopped || lookup(check, "tracking.attempts") != 0 {
+		t.Errorf("check = %v, want the attempts reset", check)
+	}
+	if next := lookup(check, "tracking.next_check_at").(time.Time); !next.Equal(now.Add(cfg.Backoff)) {
+		t.Errorf("next check at %v, want %v", next, now.Add(cfg.Backoff))
+	}
+}
+
+func TestDiffFieldsMissingEqualsZero(t *testing.T) {
+	before, _ := bson.Marshal(bson.D{{Key: "tracking", Value: bson.D{{Key: "service", Value: "Correios SEDEX"}}}, {Key: "items", Value: nil}})
+	after, _ := bson.Marshal(bson.D{
+		{Key: "tracking.service", Value: "Correios SEDEX"},
+		{Key: "tracking.code", Value: ""},
+		{Key: "tiny.total", Value: 0.0},
+		{Key: "items", Value: bson.A{}},
+		{Key: "tracking.url", Value: "https://rastreamento.correios.com.br"},
+	})
+
+	changes, err := diffFields(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "tracking.url" {
+		t.Errorf("changes = %+v, want tracking.url only", changes)
+	}
+}
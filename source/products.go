@@ -1,3 +1,222 @@
 package main
 
-// TODO AFTER
+import (
+	"context"
+	"database/sql"
+	"database_sync/database"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type MongoDBProducts struct {
+	ID                 bson.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	OldID              uint64        `json:"old_id" bson:"old_id"`
+	Name               string        `json:"name" bson:"name"`
+	Price              float64       `json:"price" bson:"price"`
+	Weight             float64       `json:"weight" bson:"weight"`
+	ProductionDeadline uint          `json:"production_deadline" bson:"production_deadline"`
+	CreatedAt          time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at" bson:"updated_at"`
+	DeletedAt          *time.Time    `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBySync      bool          `json:"deleted_by_sync,omitempty" bson:"deleted_by_sync,omitempty"`
+}
+
+type MySQLProducts struct {
+	ID        uint64          `db:"id"`
+	Nome      sql.NullString  `db:"nome"`
+	Preco     sql.NullFloat64 `db:"preco"`
+	Peso      sql.NullFloat64 `db:"peso"`
+	Prazo     sql.NullInt64   `db:"prazo"`
+	CreatedAt time.Time       `db:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at"`
+}
+
+func SyncProducts(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	mysqlDB := deps.Connections.MySQL()
+
+	ctx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
+	defer cancel()
+
+	mongoDB := deps.Connections.MongoDB()
+
+	productsCollection := mongoDB.Collection(database.COLLECTION_PRODUCTS)
+
+	allProductsMap := make(map[uint64]*MySQLProducts)
+
+	dataRows, err := mysqlDB.QueryContext(ctx, "SELECT id, nome, preco, peso, prazo, created_at, updated_at FROM produtos WHERE id IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("failed to query MySQL produtos data: %w", err)
+	}
+
+	for dataRows.Next() {
+		product := &MySQLProducts{}
+		var createdAtStr, updatedAtStr []byte
+		var id sql.NullInt64
+
+		err := dataRows.Scan(
+			&id,
+			&product.Nome,
+			&product.Preco,
+			&product.Peso,
+			&product.Prazo,
+			&createdAtStr,
+			&updatedAtStr,
+		)
+		if err != nil {
+			dataRows.Close()
+			return fmt.Errorf("failed to scan MySQL product data: %w", err)
+		}
+
+		if !id.Valid || id.Int64 <= 0 {
+			continue
+		}
+
+		product.ID = uint64(id.Int64)
+		if len(createdAtStr) > 0 {
+			product.CreatedAt, err = time.Parse("2006-01-02 15:04:05", string(createdAtStr))
+			if err != nil {
+				dataRows.Close()
+				return fmt.Errorf("failed to parse created_at datetime: %w", err)
+			}
+		}
+
+		if len(updatedAtStr) > 0 {
+			product.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", string(updatedAtStr))
+			if err != nil {
+				dataRows.Close()
+				return fmt.Errorf("failed to parse updated_at datetime: %w", err)
+			}
+		}
+
+		allProductsMap[product.ID] = product
+	}
+	dataRows.Close()
+
+	if err = dataRows.Err(); err != nil {
+		return fmt.Errorf("error iterating MySQL data rows: %w", err)
+	}
+	run.Stats.MySQLRows = len(allProductsMap)
+
+	if len(allProductsMap) == 0 {
+		run.Logger.Info("no records found in MySQL to synchronize")
+		return nil
+	}
+
+	mysqlIDs := make(map[uint64]bool, len(allProductsMap))
+	for id := range allProductsMap {
+		mysqlIDs[id] = true
+	}
+
+	mongoIDs := make(map[uint64]bool)
+	mongoProductsData := make(map[uint64]MongoDBProducts)
+
+	cursor, err := productsCollection.Find(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("failed to query MongoDB products: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product MongoDBProducts
+		if err := cursor.Decode(&product); err != nil {
+			return fmt.Errorf("failed to decode MongoDB product: %w", err)
+		}
+		run.Stats.MongoDocs++
+		if product.OldID > 0 {
+			if product.DeletedAt == nil {
+				mongoIDs[product.OldID] = true
+			}
+			mongoProductsData[product.OldID] = product
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("error iterating MongoDB cursor: %w", err)
+	}
+
+	idsToDelete := []uint64{}
+	for mongoID := range mongoIDs {
+		if !mysqlIDs[mongoID] {
+			idsToDelete = append(idsToDelete, mongoID)
+		}
+	}
+
+	deleted, err := reconcileDeletes(ctx, run, productsCollection, "old_id", idsToDelete, len(mongoIDs))
+	if err != nil {
+		return err
+	}
+	run.Stats.Deleted = deleted
+
+	idsToUpsert := []uint64{}
+
+	for id, mysqlProduct := range allProductsMap {
+		mongoProduct, exists := mongoProductsData[id]
+
+		if !exists {
+			idsToUpsert = append(idsToUpsert, id)
+			continue
+		}
+
+		if mysqlProduct.Nome.String != mongoProduct.Name ||
+			mysqlProduct.Preco.Float64 != mongoProduct.Price ||
+			mysqlProduct.Peso.Float64 != mongoProduct.Weight ||
+			uint(mysqlProduct.Prazo.Int64) != mongoProduct.ProductionDeadline ||
+			!mysqlProduct.UpdatedAt.Equal(mongoProduct.UpdatedAt) ||
+			mongoProduct.DeletedBySync {
+			idsToUpsert = append(idsToUpsert, id)
+		}
+	}
+
+	run.Stats.Skipped = len(allProductsMap) - len(idsToUpsert)
+
+	if len(idsToUpsert) == 0 {
+		return nil
+	}
+
+	batchSize := deps.Config.Sync.BatchSizeFor(len(idsToUpsert))
+
+	bulkOperations := []mongo.WriteModel{}
+
+	for _, id := range idsToUpsert {
+		product := allProductsMap[id]
+
+		mongoProduct := MongoDBProducts{
+			OldID:              product.ID,
+			Name:               product.Nome.String,
+			Price:              product.Preco.Float64,
+			Weight:             product.Peso.Float64,
+			ProductionDeadline: uint(product.Prazo.Int64),
+			CreatedAt:          product.CreatedAt,
+			UpdatedAt:          product.UpdatedAt,
+		}
+
+		filter := bson.D{{Key: "old_id", Value: mongoProduct.OldID}}
+		update := upsertUpdate(mongoProduct, mongoProductsData[id].DeletedBySync)
+		run.Changes.Upsert(id, snapshotOf(mongoProductsData, id), mongoProduct)
+
+		upsertModel := mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(update).
+			SetUpsert(true)
+
+		bulkOperations = append(bulkOperations, upsertModel)
+
+		if len(bulkOperations) >= batchSize {
+			if err := run.BulkWrite(ctx, productsCollection, bulkOperations); err != nil {
+				return fmt.Errorf("failed to execute bulk write: %w", err)
+			}
+			bulkOperations = []mongo.WriteModel{}
+		}
+	}
+
+	if len(bulkOperations) > 0 {
+		if err := run.BulkWrite(ctx, productsCollection, bulkOperations); err != nil {
+			return fmt.Errorf("failed to execute final bulk write: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type MongoDBProductLine struct {
+	Product      bson.ObjectID `json:"product,omitempty" bson:"product,omitempty"`
+	OldProductID uint64        `json:"old_product_id,omitempty" bson:"old_product_id,omitempty"`
+	Name         string        `json:"name" bson:"name"`
+	Quantity     float64       `json:"quantity" bson:"quantity"`
+	UnitPrice    float64       `json:"unit_price" bson:"unit_price"`
+	Subtotal     float64       `json:"subtotal" bson:"subtotal"`
+}
+
+var (
+	legacyProductIDKeys       = []string{"id", "produto_id", "prodId", "product_id"}
+	legacyProductNameKeys     = []string{"nome", "name", "produto", "descricao"}
+	legacyProductQuantityKeys = []string{"quantidade", "qtd", "quantity"}
+	legacyProductPriceKeys    = []string{"preco", "valor", "preco_unitario", "valor_unitario", "price"}
+	legacyProductTotalKeys    = []string{"subtotal", "total", "preco_total", "valor_total"}
+
+	// Matches "1.234" or "12.345.678": dots grouping thousands, no decimals.
+	legacyThousandsOnly = regexp.MustCompile(`^\d{1,3}(?:\.\d{3})+$`)
+
+	// Matches text rows such as "2x Camisa Dry Fit - R$ 49,90" or "3 un Bandeira".
+	legacyProductTextRow = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)\s*(?:x|un\.?|unidades?)\s+(.+?)(?:\s*[-:@]\s*(?:R\$)?\s*([\d.,]+))?$`)
+)
+
+// ParseLegacyProductsList reads the lista_produtos column, either as the JSON
+// written by the legacy app or as one product per text line. Rows that cannot
+// be understood are returned as issues instead of being dropped.
+func ParseLegacyProductsList(raw string) ([]MongoDBProductLine, []string) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || raw == "[]" || raw == "{}" || strings.EqualFold(raw, "null") {
+		return []MongoDBProductLine{}, []string{}
+	}
+
+	if strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "{") {
+		return parseLegacyProductsJSON(raw)
+	}
+
+	return parseLegacyProductsText(raw)
+}
+
+func parseLegacyProductsJSON(raw string) ([]MongoDBProductLine, []string) {
+	var rows []any
+
+	var decoded any
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return []MongoDBProductLine{}, []string{fmt.Sprintf("invalid JSON: %v", err)}
+	}
+
+	switch value := decoded.(type) {
+	case []any:
+		rows = value
+	case map[string]any:
+		if looksLikeLegacyProduct(value) {
+			rows = []any{value}
+		} else {
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+			sortLegacyRowKeys(keys)
+			for _, key := range keys {
+				rows = append(rows, value[key])
+			}
+		}
+	}
+
+	lines := make([]MongoDBProductLine, 0, len(rows))
+	issues := []string{}
+
+	for i, row := range rows {
+		item, ok := row.(map[string]any)
+		if !ok {
+			issues = append(issues, fmt.Sprintf("row %d: expected an object, got %v", i+1, row))
+			continue
+		}
+
+		line := MongoDBProductLine{}
+
+		if value, ok := lookupLegacyField(item, legacyProductIDKeys); ok {
+			if id, err := parseLegacyNumber(value); err == nil && id > 0 && id == math.Trunc(id) {
+				line.OldProductID = uint64(id)
+			}
+		}
+
+		if value, ok := lookupLegacyField(item, legacyProductNameKeys); ok {
+			line.Name = strings.TrimSpace(fmt.Sprint(value))
+		}
+
+		if line.Name == "" && line.OldProductID == 0 {
+			issues = append(issues, fmt.Sprintf("row %d: missing product name and id", i+1))
+			continue
+		}
+
+		line.Quantity = 1
+		if value, ok := lookupLegacyField(item, legacyProductQuantityKeys); ok {
+			quantity, err := parseLegacyNumber(value)
+			if err != nil || quantity <= 0 {
+				issues = append(issues, fmt.Sprintf("row %d (%s): invalid quantity %v", i+1, line.Name, value))
+				continue
+			}
+			line.Quantity = quantity
+		}
+
+		if value, ok := lookupLegacyField(item, legacyProductPriceKeys); ok {
+			price, err := parseLegacyNumber(value)
+			if err != nil {
+				issues = append(issues, fmt.Sprintf("row %d (%s): invalid unit price %v", i+1, line.Name, value))
+				continue
+			}
+			line.UnitPrice = price
+		}
+
+		if value, ok := lookupLegacyField(item, legacyProductTotalKeys); ok {
+			subtotal, err := parseLegacyNumber(value)
+			if err != nil {
+				issues = append(issues, fmt.Sprintf("row %d (%s): invalid subtotal %v", i+1, line.Name, value))
+				continue
+			}
+			line.Subtotal = subtotal
+		}
+
+		lines = append(lines, completeLegacyProductLine(line))
+	}
+
+	return lines, issues
+}
+
+func parseLegacyProductsText(raw string) ([]MongoDBProductLine, []string) {
+	lines := []MongoDBProductLine{}
+	issues := []string{}
+
+	for i, row := range strings.Split(raw, "\n") {
+		row = strings.TrimSpace(row)
+		if row == "" {
+			continue
+		}
+
+		match := legacyProductTextRow.FindStringSubmatch(row)
+		if match == nil {
+			issues = append(issues, fmt.Sprintf("line %d: unrecognized product row %q", i+1, row))
+			continue
+		}
+
+		quantity, err := parseLegacyNumber(match[1])
+		if err != nil || quantity <= 0 {
+			issues = append(issues, fmt.Sprintf("line %d: invalid quantity %q", i+1, match[1]))
+			continue
+		}
+
+		line := MongoDBProductLine{Name: strings.TrimSpace(match[2]), Quantity: quantity}
+		if match[3] != "" {
+			price, err := parseLegacyNumber(match[3])
+			if err != nil {
+				issues = append(issues, fmt.Sprintf("line %d: invalid unit price %q", i+1, match[3]))
+				continue
+			}
+			line.UnitPrice = price
+		}
+
+		lines = append(lines, completeLegacyProductLine(line))
+	}
+
+	return lines, issues
+}
+
+// sortLegacyRowKeys orders the keys of a JSON map of rows, written by the
+// legacy app as "1", "2", ..., "10". Numeric keys sort by value and come
+// before any other key, which sort as strings.
+func sortLegacyRowKeys(keys []string) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, errA := strconv.ParseUint(keys[i], 10, 64)
+		b, errB := strconv.ParseUint(keys[j], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			return a < b
+		case errA == nil || errB == nil:
+			return errA == nil
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+func completeLegacyProductLine(line MongoDBProductLine) MongoDBProductLine {
+	if line.Subtotal == 0 {
+		line.Subtotal = math.Round(line.Quantity*line.UnitPrice*100) / 100
+	} else if line.UnitPrice == 0 {
+		line.UnitPrice = math.Round(line.Subtotal/line.Quantity*100) / 100
+	}
+	return line
+}
+
+func looksLikeLegacyProduct(item map[string]any) bool {
+	_, hasName := lookupLegacyField(item, legacyProductNameKeys)
+	_, hasID := lookupLegacyField(item, legacyProductIDKeys)
+	return hasName || hasID
+}
+
+func lookupLegacyField(item map[string]any, keys []string) (any, bool) {
+	for _, key := range keys {
+		if value, ok := item[key]; ok && value != nil && fmt.Sprint(value) != "" {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// parseLegacyNumber accepts JSON numbers as well as strings written either as
+// "1234.56" or in the Brazilian format "R$ 1.234,56". In strings a comma is
+// always the decimal separator and dots group thousands; without a comma, a
+// dot is read as a decimal point unless every dot is followed by exactly
+// three digits, so "1.234" is 1234 while "49.9" and "1234.56" keep decimals.
+func parseLegacyNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	}
+
+	s := strings.TrimSpace(fmt.Sprint(value))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "R$"))
+	s = strings.ReplaceAll(s, " ", "")
+
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	} else if legacyThousandsOnly.MatchString(s) {
+		s = strings.ReplaceAll(s, ".", "")
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+type productRefs struct {
+	byOldID map[uint64]bson.ObjectID
+	byName  map[string]bson.ObjectID
+}
+
+func loadProductRefs(ctx context.Context, productsCollection *mongo.Collection) (*productRefs, error) {
+	refs := &productRefs{
+		byOldID: make(map[uint64]bson.ObjectID),
+		byName:  make(map[string]bson.ObjectID),
+	}
+
+	cursor, err := productsCollection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MongoDB products: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product struct {
+			ID    bson.ObjectID `bson:"_id"`
+			OldID uint64        `bson:"old_id"`
+			Name  string        `bson:"name"`
+		}
+		if err := cursor.Decode(&product); err != nil {
+			continue
+		}
+		if product.OldID > 0 {
+			refs.byOldID[product.OldID] = product.ID
+		}
+		if product.Name != "" {
+			refs.byName[strings.ToLower(strings.TrimSpace(product.Name))] = product.ID
+		}
+	}
+
+	return refs, cursor.Err()
+}
+
+func (r *productRefs) resolve(lines []MongoDBProductLine) []MongoDBProductLine {
+	for i := range lines {
+		if oid, ok := r.byOldID[lines[i].OldProductID]; ok && lines[i].OldProductID > 0 {
+			lines[i].Product = oid
+			continue
+		}
+		if oid, ok := r.byName[strings.ToLower(lines[i].Name)]; ok {
+			lines[i].Product = oid
+		}
+	}
+	return lines
+}
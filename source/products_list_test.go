@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLegacyProductsList(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		lines  []MongoDBProductLine
+		issues int
+	}{
+		{
+			name:  "empty",
+			raw:   " null ",
+			lines: []MongoDBProductLine{},
+		},
+		{
+			name: "JSON array",
+			raw:  `[{"id": 12, "nome": "Camisa", "quantidade": "2", "preco": "49,90"}, {"name": "Bandeira", "qtd": 1, "total": 30}]`,
+			lines: []MongoDBProductLine{
+				{OldProductID: 12, Name: "Camisa", Quantity: 2, UnitPrice: 49.9, Subtotal: 99.8},
+				{Name: "Bandeira", Quantity: 1, UnitPrice: 30, Subtotal: 30},
+			},
+		},
+		{
+			name: "JSON map sorted by numeric key",
+			raw:  `{"10": {"nome": "Dez"}, "2": {"nome": "Dois"}, "1": {"nome": "Um"}, "extra": {"nome": "Extra"}}`,
+			lines: []MongoDBProductLine{
+				{Name: "Um", Quantity: 1},
+				{Name: "Dois", Quantity: 1},
+				{Name: "Dez", Quantity: 1},
+				{Name: "Extra", Quantity: 1},
+			},
+		},
+		{
+			name:  "JSON single product",
+			raw:   `{"produto": "Boné", "valor": "R$ 1.234"}`,
+			lines: []MongoDBProductLine{{Name: "Boné", Quantity: 1, UnitPrice: 1234, Subtotal: 1234}},
+		},
+		{
+			name: "text",
+			raw:  "2x Camisa Dry Fit - R$ 49,90\n\n3 un Bandeira\n1x Troféu: 1.234,50",
+			lines: []MongoDBProductLine{
+				{Name: "Camisa Dry Fit", Quantity: 2, UnitPrice: 49.9, Subtotal: 99.8},
+				{Name: "Bandeira", Quantity: 3},
+				{Name: "Troféu", Quantity: 1, UnitPrice: 1234.5, Subtotal: 1234.5},
+			},
+		},
+		{
+			name:   "malformed JSON",
+			raw:    `[{"nome": "Camisa"`,
+			lines:  []MongoDBProductLine{},
+			issues: 1,
+		},
+		{
+			name:   "bad rows are reported",
+			raw:    `[{"nome": "Camisa", "quantidade": 0}, "Bandeira", {"preco": 10}, {"nome": "Boné"}]`,
+			lines:  []MongoDBProductLine{{Name: "Boné", Quantity: 1}},
+			issues: 3,
+		},
+		{
+			name:   "unrecognized text row",
+			raw:    "2x Camisa\nsem quantidade",
+			lines:  []MongoDBProductLine{{Name: "Camisa", Quantity: 2}},
+			issues: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, issues := ParseLegacyProductsList(test.raw)
+			if !reflect.DeepEqual(lines, test.lines) {
+				t.Errorf("lines = %+v, want %+v", lines, test.lines)
+			}
+			if len(issues) != test.issues {
+				t.Errorf("issues = %q, want %d of them", issues, test.issues)
+			}
+		})
+	}
+}
+
+func TestParseLegacyNumber(t *testing.T) {
+	tests := []struct {
+		value any
+		want  float64
+	}{
+		{"1234.56", 1234.56},
+		{"49.9", 49.9},
+		{"1.234", 1234},
+		{"12.345.678", 12345678},
+		{"R$ 1.234,56", 1234.56},
+		{"49,90", 49.9},
+		{"1.5", 1.5},
+		{float64(7), 7},
+	}
+	for _, test := range tests {
+		got, err := parseLegacyNumber(test.value)
+		if err != nil || got != test.want {
+			t.Errorf("parseLegacyNumber(%q) = %v, %v; want %v", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "1,2,3"} {
+		if _, err := parseLegacyNumber(value); err == nil {
+			t.Errorf("parseLegacyNumber(%q) succeeded, want an error", value)
+		}
+	}
+}
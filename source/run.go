@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+type SyncStats struct {
+	MySQLRows  int   `json:"mysql_rows" bson:"mysql_rows"`
+	MongoDocs  int   `json:"mongo_docs" bson:"mongo_docs"`
+	Inserted   int64 `json:"inserted" bson:"inserted"`
+	Updated    int64 `json:"updated" bson:"updated"`
+	Deleted    int64 `json:"deleted" bson:"deleted"`
+	Skipped    int   `json:"skipped" bson:"skipped"`
+	Failed     int   `json:"failed" bson:"failed"`
+	BulkWrites int   `json:"bulk_writes" bson:"bulk_writes"`
+}
+
+func (s SyncStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int("mysql_rows", s.MySQLRows),
+		slog.Int("mongo_docs", s.MongoDocs),
+		slog.Int64("inserted", s.Inserted),
+		slog.Int64("updated", s.Updated),
+		slog.Int64("deleted", s.Deleted),
+		slog.Int("skipped", s.Skipped),
+		slog.Int("failed", s.Failed),
+		slog.Int("bulk_writes", s.BulkWrites),
+	)
+}
+
+// SyncRun is the state of one execution of a sync job. Jobs fill Stats as
+// they go, so partial counts are still reported when a run fails midway.
+// Logger carries the run, cycle and entity fields, so every line a job
+// writes can be correlated with the others of the same cycle.
+type SyncRun struct {
+	ID        string
+	CycleID   string
+	Entity    string
+	StartedAt time.Time
+	Stats     SyncStats
+	FailedIDs []string
+	Changes   *ChangeLog
+	DryRun    bool
+	Report    *DryRunReport
+	// ForceFullSync makes incremental jobs ignore their watermark.
+	ForceFullSync bool
+	// DeletePolicy, DeleteMaxPercent and WriteTimeout are the settings of
+	// the entity, resolved from the configuration when the run starts.
+	DeletePolicy     DeletePolicy
+	DeleteMaxPercent float64
+	WriteTimeout     time.Duration
+	Logger           *slog.Logger
+}
+
+func NewSyncRun(entity, cycleID string) *SyncRun {
+	id := newRunID()
+	return &SyncRun{
+		ID:        id,
+		CycleID:   cycleID,
+		Entity:    entity,
+		StartedAt: time.Now(),
+		Logger:    slog.With("run_id", id, "cycle_id", cycleID, "entity", entity),
+	}
+}
+
+// RecordFailure counts a record that could not be synchronized and keeps its
+// ID, up to MAX_FAILED_IDS, for the run history.
+func (r *SyncRun) RecordFailure(id string) {
+	r.Stats.Failed++
+	if len(r.FailedIDs) < MAX_FAILED_IDS {
+		r.FailedIDs = append(r.FailedIDs, id)
+	}
+}
+
+func newRunID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (r *SyncRun) RecordBulkWrite(result *mongo.BulkWriteResult) {
+	r.Stats.BulkWrites++
+	if result != nil {
+		r.Stats.Inserted += result.UpsertedCount + result.InsertedCount
+		r.Stats.Updated += result.ModifiedCount
+	}
+}
+
+type RunSummary struct {
+	RunID     string        `json:"run_id,omitempty"`
+	CycleID   string        `json:"cycle_id,omitempty"`
+	Entity    string        `json:"entity"`
+	Outcome   JobOutcome    `json:"outcome"`
+	StartedAt time.Time     `json:"started_at,omitzero"`
+	Duration  string        `json:"duration,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Stats     *SyncStats    `json:"stats,omitempty"`
+	DryRun    *DryRunReport `json:"dry_run,omitempty"`
+}
+
+func newRunSummary(run *SyncRun, err error) RunSummary {
+	stats := run.Stats
+	summary := RunSummary{
+		RunID:     run.ID,
+		CycleID:   run.CycleID,
+		Entity:    run.Entity,
+		Outcome:   JobSucceeded,
+		StartedAt: run.StartedAt,
+		Duration:  time.Since(run.StartedAt).String(),
+		Stats:     &stats,
+		DryRun:    run.Report,
+	}
+	if err != nil {
+		summary.Outcome = JobFailed
+		summary.Error = err.Error()
+	}
+	return summary
+}
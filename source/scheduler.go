@@ -0,0 +1,572 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownJob = errors.New("unknown synchronization job")
+	ErrJobRunning = errors.New("synchronization already in progress")
+	ErrStopping   = errors.New("synchronizer is shutting down")
+)
+
+type JobOutcome string
+
+const (
+	JobSucceeded JobOutcome = "succeeded"
+	JobFailed    JobOutcome = "failed"
+	JobSkipped   JobOutcome = "skipped"
+	JobBlocked   JobOutcome = "blocked"
+)
+
+// SyncJob is a single synchronization step. The lock/running pair is the
+// overlap guard kept in main.go, so a job never runs twice at the same time.
+type SyncJob struct {
+	Name      string
+	DependsOn []string
+	Run       func(ctx context.Context, deps *SyncDeps, run *SyncRun) error
+
+	lock     *sync.Mutex
+	running  *bool
+	status   JobStatus
+	schedule *JobSchedule
+	nextRun  time.Time
+}
+
+type JobStatus struct {
+	Name         string             `json:"name"`
+	DependsOn    []string           `json:"depends_on,omitempty"`
+	Running      bool               `json:"running"`
+	LastOutcome  JobOutcome         `json:"last_outcome,omitempty"`
+	LastStart    time.Time          `json:"last_start,omitzero"`
+	LastSuccess  time.Time          `json:"last_success,omitzero"`
+	LastError    string             `json:"last_error,omitempty"`
+	LastErrorAt  time.Time          `json:"last_error_at,omitzero"`
+	LastDuration string             `json:"last_duration,omitempty"`
+	LastStats    *SyncStats         `json:"last_stats,omitempty"`
+	Schedule     *JobScheduleStatus `json:"schedule,omitempty"`
+}
+
+func (j *SyncJob) tryStart(run *SyncRun) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if *j.running {
+		return false
+	}
+	*j.running = true
+	j.status.LastStart = run.StartedAt
+	return true
+}
+
+func (j *SyncJob) finish(run *SyncRun, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	*j.running = false
+	j.status.LastDuration = time.Since(run.StartedAt).String()
+	stats := run.Stats
+	j.status.LastStats = &stats
+
+	if err != nil {
+		j.status.LastOutcome = JobFailed
+		j.status.LastError = err.Error()
+		j.status.LastErrorAt = time.Now()
+		return
+	}
+	j.status.LastOutcome = JobSucceeded
+	j.status.LastSuccess = time.Now()
+}
+
+func (j *SyncJob) recordOutcome(outcome JobOutcome) {
+	j.lock.Lock()
+	j.status.LastOutcome = outcome
+	j.lock.Unlock()
+}
+
+// restore seeds the status with the run history, so a restart does not
+// report every job as never run.
+func (j *SyncJob) restore(last SyncRunRecord, lastSuccess *SyncRunRecord) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	stats := last.Stats
+	j.status.LastOutcome = last.Status
+	j.status.LastStart = last.StartedAt
+	j.status.LastDuration = time.Duration(last.DurationSeconds * float64(time.Second)).String()
+	j.status.LastStats = &stats
+	if last.Status == JobFailed {
+		j.status.LastError = last.Error
+		j.status.LastErrorAt = last.FinishedAt
+	}
+	if lastSuccess != nil {
+		j.status.LastSuccess = lastSuccess.FinishedAt
+	}
+}
+
+func (j *SyncJob) Status() JobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	status := j.status
+	status.Name = j.Name
+	status.DependsOn = j.DependsOn
+	status.Running = *j.running
+	if j.schedule != nil {
+		status.Schedule = &JobScheduleStatus{
+			Spec:    j.schedule.Spec,
+			Enabled: j.schedule.Enabled,
+			NextRun: j.nextRun,
+		}
+		if j.schedule.Jitter > 0 {
+			status.Schedule.Jitter = j.schedule.Jitter.String()
+		}
+	}
+	return status
+}
+
+func (j *SyncJob) setNextRun(next time.Time) {
+	j.lock.Lock()
+	j.nextRun = next
+	j.lock.Unlock()
+}
+
+type Scheduler struct {
+	deps          *SyncDeps
+	jobs          map[string]*SyncJob
+	order         []*SyncJob
+	dryRun        bool
+	forceFullSync bool
+	inFlight      sync.WaitGroup
+	// ping checks the databases before a cycle or a scheduled run.
+	ping func(ctx context.Context) error
+}
+
+// jobNames returns the names of jobs, in order.
+func jobNames(jobs []*SyncJob) []string {
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+func NewScheduler(deps *SyncDeps, jobs ...*SyncJob) (*Scheduler, error) {
+	s := &Scheduler{deps: deps, jobs: make(map[string]*SyncJob, len(jobs))}
+	// Connections are opened after the scheduler is built.
+	s.ping = func(ctx context.Context) error {
+		return deps.Connections.Ping(ctx)
+	}
+
+	for _, job := range jobs {
+		if _, exists := s.jobs[job.Name]; exists {
+			return nil, fmt.Errorf("duplicated sync job %q", job.Name)
+		}
+		s.jobs[job.Name] = job
+	}
+
+	for _, job := range jobs {
+		for _, dep := range job.DependsOn {
+			if _, exists := s.jobs[dep]; !exists {
+				return nil, fmt.Errorf("sync job %q depends on unknown job %q", job.Name, dep)
+			}
+		}
+	}
+
+	visited := make(map[string]bool, len(jobs))
+	visiting := make(map[string]bool, len(jobs))
+
+	var visit func(job *SyncJob, path []string) error
+	visit = func(job *SyncJob, path []string) error {
+		if visited[job.Name] {
+			return nil
+		}
+		if visiting[job.Name] {
+			return fmt.Errorf("dependency cycle between sync jobs: %s", strings.Join(append(path, job.Name), " -> "))
+		}
+
+		visiting[job.Name] = true
+		for _, dep := range job.DependsOn {
+			if err := visit(s.jobs[dep], append(path, job.Name)); err != nil {
+				return err
+			}
+		}
+		visiting[job.Name] = false
+		visited[job.Name] = true
+
+		s.order = append(s.order, job)
+		return nil
+	}
+
+	for _, job := range jobs {
+		if err := visit(job, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// RunCycle starts every job once, each one as soon as all of its
+// dependencies have succeeded. A job whose dependency failed, was skipped or
+// was blocked is itself blocked, so it never writes references built from
+// stale data. It returns when every job of the cycle has finished.
+func (s *Scheduler) RunCycle(ctx context.Context) []RunSummary {
+	cycleID := newRunID()
+	logger := slog.With("cycle_id", cycleID)
+	logger.Info("starting synchronization cycle")
+
+	if err := s.ping(ctx); err != nil {
+		logger.Error("databases unavailable, skipping synchronization cycle", "error", err)
+		summaries := make([]RunSummary, 0, len(s.order))
+		for _, job := range s.order {
+			summaries = append(summaries, RunSummary{CycleID: cycleID, Entity: job.Name, Outcome: JobBlocked, Error: err.Error()})
+		}
+		return summaries
+	}
+
+	done := make(map[string]chan struct{}, len(s.order))
+	for _, job := range s.order {
+		done[job.Name] = make(chan struct{})
+	}
+
+	var mu sync.Mutex
+	summaries := make(map[string]RunSummary, len(s.order))
+
+	var wg sync.WaitGroup
+	for _, job := range s.order {
+		wg.Add(1)
+		go func(job *SyncJob) {
+			defer wg.Done()
+			defer close(done[job.Name])
+
+			for _, dep := range job.DependsOn {
+				<-done[dep]
+			}
+
+			mu.Lock()
+			var blockedBy []string
+			for _, dep := range job.DependsOn {
+				if summaries[dep].Outcome != JobSucceeded {
+					blockedBy = append(blockedBy, fmt.Sprintf("%s (%s)", dep, summaries[dep].Outcome))
+				}
+			}
+			mu.Unlock()
+
+			var summary RunSummary
+			if len(blockedBy) > 0 {
+				logger.Warn("skipping synchronization, prerequisites not met", "entity", job.Name, "blocked_by", blockedBy)
+				job.recordOutcome(JobBlocked)
+				observeOutcome(job.Name, JobBlocked)
+				summary = RunSummary{CycleID: cycleID, Entity: job.Name, Outcome: JobBlocked, Error: "prerequisites not met: " + strings.Join(blockedBy, ", ")}
+			} else {
+				summary = s.runJob(ctx, job, cycleID)
+			}
+
+			mu.Lock()
+			summaries[job.Name] = summary
+			mu.Unlock()
+		}(job)
+	}
+	wg.Wait()
+
+	ordered := make([]RunSummary, 0, len(s.order))
+	for _, job := range s.order {
+		ordered = append(ordered, summaries[job.Name])
+	}
+	return ordered
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job *SyncJob, cycleID string) RunSummary {
+	run := s.newRun(job, cycleID)
+	if ctx.Err() != nil {
+		return RunSummary{RunID: run.ID, CycleID: cycleID, Entity: job.Name, Outcome: JobSkipped, Error: ErrStopping.Error()}
+	}
+	if !s.start(job, run) {
+		run.Logger.Warn("synchronization already in progress, skipping")
+		observeOutcome(job.Name, JobSkipped)
+		return RunSummary{RunID: run.ID, CycleID: cycleID, Entity: job.Name, Outcome: JobSkipped}
+	}
+
+	return s.execute(ctx, job, run)
+}
+
+// start takes the overlap guard and counts the run as in flight, so Wait
+// can hold the shutdown until it finished.
+func (s *Scheduler) start(job *SyncJob, run *SyncRun) bool {
+	if !job.tryStart(run) {
+		return false
+	}
+	s.inFlight.Add(1)
+	return true
+}
+
+// Wait blocks until every run in flight finished, or the timeout expired.
+// It reports whether all of them finished.
+func (s *Scheduler) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// LoadSchedules reads the schedule of every job. It fails on the first
+// invalid configuration, so the daemon refuses to start with it.
+func (s *Scheduler) LoadSchedules() error {
+	schedules := s.deps.Config.Schedules
+	for name := range schedules.Jobs {
+		if !s.HasJob(name) {
+			return fmt.Errorf("schedule configured for unknown job %q, expected one of: %s", name, strings.Join(s.JobNames(), ", "))
+		}
+	}
+
+	for _, job := range s.order {
+		schedule, err := LoadJobSchedule(job.Name, schedules.For(job.Name))
+		if err != nil {
+			return err
+		}
+		job.lock.Lock()
+		job.schedule = &schedule
+		job.lock.Unlock()
+	}
+	return nil
+}
+
+// Start runs every enabled job on its own schedule until ctx is done. A
+// scheduled run that comes while the previous one is still going is skipped
+// by the overlap guard, as in a cycle.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.order {
+		if job.schedule == nil || !job.schedule.Enabled {
+			slog.Info("job disabled, not scheduling it", "entity", job.Name)
+			continue
+		}
+		go s.scheduleLoop(ctx, job)
+	}
+}
+
+func (s *Scheduler) scheduleLoop(ctx context.Context, job *SyncJob) {
+	for {
+		next := job.schedule.Next(time.Now())
+		job.setNextRun(next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		go s.runScheduled(ctx, job)
+	}
+}
+
+// runScheduled starts a job from its own schedule.
+func (s *Scheduler) runScheduled(ctx context.Context, job *SyncJob) {
+	if blockedBy := s.failedDependencies(job); len(blockedBy) > 0 {
+		slog.Warn("skipping synchronization, prerequisites not met", "entity", job.Name, "blocked_by", blockedBy)
+		job.recordOutcome(JobBlocked)
+		observeOutcome(job.Name, JobBlocked)
+		return
+	}
+
+	if err := s.ping(ctx); err != nil {
+		slog.Error("databases unavailable, skipping synchronization", "entity", job.Name, "error", err)
+		return
+	}
+
+	s.runJob(ctx, job, newRunID())
+}
+
+// failedDependencies lists the dependencies keeping a scheduled job from
+// running. Outside of a cycle, only a dependency whose last run failed, or
+// was blocked by a failure of its own dependencies, holds the job back. A
+// dependency that never ran does not, or nothing would run on the first
+// ticks after a fresh deploy, and neither does a disabled one.
+func (s *Scheduler) failedDependencies(job *SyncJob) []string {
+	var blockedBy []string
+	for _, dep := range job.DependsOn {
+		status := s.jobs[dep].Status()
+		if status.Schedule != nil && !status.Schedule.Enabled {
+			continue
+		}
+		if status.LastOutcome == JobFailed || status.LastOutcome == JobBlocked {
+			blockedBy = append(blockedBy, fmt.Sprintf("%s (%s)", dep, status.LastOutcome))
+		}
+	}
+	return blockedBy
+}
+
+// SetDryRun makes every following run compute its writes without applying
+// them. The runs report what they would have written instead.
+func (s *Scheduler) SetDryRun(dryRun bool) {
+	s.dryRun = dryRun
+}
+
+// SetForceFullSync makes every following run a full reconciliation,
+// whatever the watermarks say.
+func (s *Scheduler) SetForceFullSync(force bool) {
+	s.forceFullSync = force
+}
+
+func (s *Scheduler) newRun(job *SyncJob, cycleID string) *SyncRun {
+	run := NewSyncRun(job.Name, cycleID)
+	run.ForceFullSync = s.forceFullSync
+	run.DeletePolicy = DeletePolicy(s.deps.Config.Delete.PolicyFor(job.Name))
+	run.DeleteMaxPercent = s.deps.Config.Delete.MaxPercent
+	run.WriteTimeout = s.deps.Config.Sync.WriteTimeout
+	if s.dryRun {
+		run.DryRun = true
+		run.Report = &DryRunReport{}
+		run.Logger = run.Logger.With("dry_run", true)
+	} else if s.deps.Config.Sync.ChangeLog {
+		run.Changes = NewChangeLog(s.deps.Connections.MongoDB(), run)
+	}
+	return run
+}
+
+// execute runs a job whose overlap guard is already held by the caller,
+// through start.
+func (s *Scheduler) execute(ctx context.Context, job *SyncJob, run *SyncRun) RunSummary {
+	defer s.inFlight.Done()
+
+	run.Logger.Info("running synchronization")
+	// The error ends up in /status and in the run history, so it must not
+	// carry a connection string a driver echoed back.
+	err := s.deps.Config.RedactError(job.Run(ctx, s.deps, run))
+	job.finish(run, err)
+	observeRun(run, err)
+	if !run.DryRun {
+		s.saveRun(run, err)
+	}
+
+	summary := newRunSummary(run, err)
+	if err != nil {
+		run.Logger.Error("synchronization failed", "error", err, "duration", summary.Duration, "stats", run.Stats)
+		return summary
+	}
+
+	run.Logger.Info("synchronization completed", "duration", summary.Duration, "stats", run.Stats)
+	return summary
+}
+
+func (s *Scheduler) saveRun(run *SyncRun, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := SaveSyncRun(ctx, s.deps.Connections.MongoDB(), NewSyncRunRecord(run, err)); err != nil {
+		run.Logger.Warn("failed to save sync run history", "error", err)
+	}
+}
+
+// RestoreStatus loads the last run of every job from the run history.
+func (s *Scheduler) RestoreStatus(ctx context.Context) error {
+	db := s.deps.Connections.MongoDB()
+
+	for _, job := range s.order {
+		last, err := FindSyncRuns(ctx, db, SyncRunFilter{Entity: job.Name, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(last) == 0 {
+			continue
+		}
+
+		var lastSuccess *SyncRunRecord
+		if last[0].Status == JobSucceeded {
+			lastSuccess = &last[0]
+		} else {
+			succeeded, err := FindSyncRuns(ctx, db, SyncRunFilter{Entity: job.Name, Status: string(JobSucceeded), Limit: 1})
+			if err != nil {
+				return err
+			}
+			if len(succeeded) > 0 {
+				lastSuccess = &succeeded[0]
+			}
+		}
+
+		job.restore(last[0], lastSuccess)
+	}
+
+	return nil
+}
+
+// Trigger starts a single job right away, outside of the regular cycle and
+// without waiting for its dependencies. It fails with ErrJobRunning instead
+// of queueing when the job is already in progress. The run uses ctx, so it
+// must outlive the caller's request.
+func (s *Scheduler) Trigger(ctx context.Context, name string) (<-chan RunSummary, error) {
+	job, exists := s.jobs[name]
+	if !exists {
+		return nil, ErrUnknownJob
+	}
+	if ctx.Err() != nil {
+		return nil, ErrStopping
+	}
+
+	run := s.newRun(job, newRunID())
+	if !s.start(job, run) {
+		return nil, ErrJobRunning
+	}
+
+	result := make(chan RunSummary, 1)
+	go func() {
+		result <- s.execute(ctx, job, run)
+	}()
+	return result, nil
+}
+
+// TriggerCycle starts a full cycle right away unless any job is running.
+func (s *Scheduler) TriggerCycle(ctx context.Context) (<-chan []RunSummary, error) {
+	if ctx.Err() != nil {
+		return nil, ErrStopping
+	}
+	for _, job := range s.order {
+		if job.Status().Running {
+			return nil, fmt.Errorf("%w: %s", ErrJobRunning, job.Name)
+		}
+	}
+
+	result := make(chan []RunSummary, 1)
+	go func() {
+		result <- s.RunCycle(ctx)
+	}()
+	return result, nil
+}
+
+func (s *Scheduler) HasJob(name string) bool {
+	_, exists := s.jobs[name]
+	return exists
+}
+
+func (s *Scheduler) JobNames() []string {
+	names := make([]string, 0, len(s.order))
+	for _, job := range s.order {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+// Status returns a snapshot of every job, in dependency order.
+func (s *Scheduler) Status() []JobStatus {
+	statuses := make([]JobStatus, 0, len(s.order))
+	for _, job := range s.order {
+		statuses = append(statuses, job.Status())
+	}
+	return statuses
+}
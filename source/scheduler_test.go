@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database_sync/config"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// jobRecorder keeps the order in which test jobs started and finished.
+type jobRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *jobRecorder) record(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *jobRecorder) index(event string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Index(r.events, event)
+}
+
+func (r *jobRecorder) job(name string, err error, dependsOn ...string) *SyncJob {
+	return &SyncJob{
+		Name:      name,
+		DependsOn: dependsOn,
+		Run: func(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+			r.record(name + " start")
+			r.record(name + " end")
+			return err
+		},
+		lock:    &sync.Mutex{},
+		running: new(bool),
+	}
+}
+
+func newTestScheduler(t *testing.T, jobs ...*SyncJob) *Scheduler {
+	t.Helper()
+	scheduler, err := NewScheduler(&SyncDeps{Config: config.Default()}, jobs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.ping = func(context.Context) error { return nil }
+	// Dry runs keep the run history out of MongoDB.
+	scheduler.SetDryRun(true)
+	return scheduler
+}
+
+func outcomes(summaries []RunSummary) map[string]JobOutcome {
+	outcomes := make(map[string]JobOutcome, len(summaries))
+	for _, summary := range summaries {
+		outcomes[summary.Entity] = summary.Outcome
+	}
+	return outcomes
+}
+
+func TestNewSchedulerOrder(t *testing.T) {
+	recorder := &jobRecorder{}
+	scheduler := newTestScheduler(t,
+		recorder.job("orders", nil, "budgets", "users"),
+		recorder.job("budgets", nil, "users"),
+		recorder.job("users", nil),
+	)
+	if names := scheduler.JobNames(); !slices.Equal(names, []string{"users", "budgets", "orders"}) {
+		t.Errorf("order = %v, want dependencies first", names)
+	}
+
+	_, err := NewScheduler(&SyncDeps{Config: config.Default()},
+		recorder.job("a", nil, "b"),
+		recorder.job("b", nil, "a"),
+	)
+	if err == nil {
+		t.Error("a dependency cycle was accepted")
+	}
+}
+
+func TestSyncJobs(t *testing.T) {
+	jobs := syncJobs()
+	scheduler, err := NewScheduler(&SyncDeps{Config: config.Default()}, jobs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configuration accepts the settings of the jobs the daemon runs.
+	if names := jobNames(jobs); !slices.Equal(names, scheduler.JobNames()) {
+		t.Errorf("job names = %v, want those of the scheduler %v", names, scheduler.JobNames())
+	}
+}
+
+func TestRunCycleOrdering(t *testing.T) {
+	recorder := &jobRecorder{}
+	scheduler := newTestScheduler(t,
+		recorder.job("users", nil),
+		recorder.job("products", nil),
+		recorder.job("leads", nil, "users"),
+		recorder.job("budgets", nil, "users", "products", "leads"),
+		recorder.job("orders", nil, "budgets"),
+	)
+
+	summaries := scheduler.RunCycle(context.Background())
+
+	var entities []string
+	for _, summary := range summaries {
+		entities = append(entities, summary.Entity)
+		if summary.Outcome != JobSucceeded {
+			t.Errorf("%s: outcome %s, want succeeded", summary.Entity, summary.Outcome)
+		}
+	}
+	if !slices.Equal(entities, scheduler.JobNames()) {
+		t.Errorf("summaries = %v, want them in dependency order %v", entities, scheduler.JobNames())
+	}
+
+	for _, job := range scheduler.order {
+		for _, dep := range job.DependsOn {
+			if recorder.index(job.Name+" start") < recorder.index(dep+" end") {
+				t.Errorf("%s started before its dependency %s finished: %v", job.Name, dep, recorder.events)
+			}
+		}
+	}
+}
+
+func TestRunCycleBlocksDependents(t *testing.T) {
+	recorder := &jobRecorder{}
+	scheduler := newTestScheduler(t,
+		recorder.job("users", errors.New("MySQL went away")),
+		recorder.job("products", nil),
+		recorder.job("leads", nil, "users"),
+		recorder.job("budgets", nil, "products", "leads"),
+	)
+
+	got := outcomes(scheduler.RunCycle(context.Background()))
+	want := map[string]JobOutcome{"users": JobFailed, "products": JobSucceeded, "leads": JobBlocked, "budgets": JobBlocked}
+	for entity, outcome := range want {
+		if got[entity] != outcome {
+			t.Errorf("%s: outcome %s, want %s", entity, got[entity], outcome)
+		}
+	}
+	if recorder.index("leads start") >= 0 || recorder.index("budgets start") >= 0 {
+		t.Errorf("blocked jobs ran: %v", recorder.events)
+	}
+}
+
+func TestRunCycleUnhealthyDatabases(t *testing.T) {
+	recorder := &jobRecorder{}
+	scheduler := newTestScheduler(t, recorder.job("users", nil), recorder.job("leads", nil, "users"))
+	scheduler.ping = func(context.Context) error { return errors.New("connection refused") }
+
+	for _, summary := range scheduler.RunCycle(context.Background()) {
+		if summary.Outcome != JobBlocked {
+			t.Errorf("%s: outcome %s, want blocked", summary.Entity, summary.Outcome)
+		}
+	}
+	if len(recorder.events) > 0 {
+		t.Errorf("jobs ran without databases: %v", recorder.events)
+	}
+}
+
+func TestFailedDependencies(t *testing.T) {
+	recorder := &jobRecorder{}
+	users := recorder.job("users", nil)
+	products := recorder.job("products", nil)
+	budgets := recorder.job("budgets", nil, "users", "products")
+	scheduler := newTestScheduler(t, users, products, budgets)
+
+	if blockedBy := scheduler.failedDependencies(budgets); len(blockedBy) > 0 {
+		t.Errorf("dependencies that never ran block the job: %v", blockedBy)
+	}
+
+	users.recordOutcome(JobFailed)
+	products.recordOutcome(JobSkipped)
+	if blockedBy := scheduler.failedDependencies(budgets); !slices.Equal(blockedBy, []string{"users (failed)"}) {
+		t.Errorf("blocked by %v, want the failed users only", blockedBy)
+	}
+
+	users.schedule = &JobSchedule{Enabled: false}
+	if blockedBy := scheduler.failedDependencies(budgets); len(blockedBy) > 0 {
+		t.Errorf("a disabled dependency blocks the job: %v", blockedBy)
+	}
+
+	products.recordOutcome(JobBlocked)
+	if blockedBy := scheduler.failedDependencies(budgets); !slices.Equal(blockedBy, []string{"products (blocked)"}) {
+		t.Errorf("blocked by %v, want the blocked products", blockedBy)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const HTTP_READ_HEADER_TIMEOUT = 10 * time.Second
+
+type StatusServer struct {
+	// ctx is the process context. Runs triggered over HTTP use it instead
+	// of the request context, so they are only canceled by a shutdown.
+	ctx       context.Context
+	deps      *SyncDeps
+	scheduler *Scheduler
+	startedAt time.Time
+}
+
+func NewHTTPServer(ctx context.Context, deps *SyncDeps, scheduler *Scheduler) *http.Server {
+	s := &StatusServer{ctx: ctx, deps: deps, scheduler: scheduler, startedAt: time.Now()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("POST /sync", s.handleSyncAll)
+	mux.HandleFunc("POST /sync/{entity}", s.handleSyncEntity)
+	mux.HandleFunc("GET /runs", s.handleRuns)
+	mux.HandleFunc("GET /runs/{id}", s.handleRun)
+
+	return &http.Server{
+		Addr:              ":" + deps.Config.HTTP.Port,
+		Handler:           mux,
+		ReadHeaderTimeout: HTTP_READ_HEADER_TIMEOUT,
+	}
+}
+
+// StartHTTPServer serves in the background. name tells the status server
+// and the webhook server apart in the logs.
+func StartHTTPServer(name string, server *http.Server) {
+	go func() {
+		slog.Info("HTTP server listening", "server", name, "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "server", name, "error", err)
+		}
+	}()
+}
+
+func (s *StatusServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *StatusServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.ctx.Err() != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "stopping"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := s.deps.Connections.Ping(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": s.deps.Config.Redact(err.Error())})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func (s *StatusServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"started_at": s.startedAt,
+		"uptime":     time.Since(s.startedAt).String(),
+		"jobs":       s.scheduler.Status(),
+	})
+}
+
+// handleSyncAll starts a full cycle. With ?wait=true the response is held
+// until every job finished and carries the run summaries.
+func (s *StatusServer) handleSyncAll(w http.ResponseWriter, r *http.Request) {
+	result, err := s.scheduler.TriggerCycle(s.ctx)
+	if err != nil {
+		writeSyncError(w, err)
+		return
+	}
+
+	if !waitRequested(r) {
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "started", "jobs": s.scheduler.JobNames()})
+		return
+	}
+
+	select {
+	case summaries := <-result:
+		status := http.StatusOK
+		for _, summary := range summaries {
+			if summary.Outcome != JobSucceeded {
+				status = http.StatusInternalServerError
+			}
+		}
+		writeJSON(w, status, map[string]any{"runs": summaries})
+	case <-r.Context().Done():
+	}
+}
+
+func (s *StatusServer) handleSyncEntity(w http.ResponseWriter, r *http.Request) {
+	result, err := s.scheduler.Trigger(s.ctx, r.PathValue("entity"))
+	if err != nil {
+		writeSyncError(w, err)
+		return
+	}
+
+	if !waitRequested(r) {
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "started", "entity": r.PathValue("entity")})
+		return
+	}
+
+	select {
+	case summary := <-result:
+		status := http.StatusOK
+		if summary.Outcome != JobSucceeded {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, summary)
+	case <-r.Context().Done():
+	}
+}
+
+// handleRuns lists the run history, newest first, filtered by the entity,
+// status, failed_id and limit query parameters. For example,
+// ?entity=orders&status=succeeded&limit=1 is the last successful orders
+// sync, and ?failed_id=<id> lists the runs where that record failed.
+func (s *StatusServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := SyncRunFilter{
+		Entity:   query.Get("entity"),
+		Status:   query.Get("status"),
+		FailedID: query.Get("failed_id"),
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	runs, err := FindSyncRuns(r.Context(), s.deps.Connections.MongoDB(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": s.deps.Config.Redact(err.Error())})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+}
+
+func (s *StatusServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	run, err := FindSyncRun(r.Context(), s.deps.Connections.MongoDB(), r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": s.deps.Config.Redact(err.Error())})
+		return
+	}
+	if run == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "sync run not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+func waitRequested(r *http.Request) bool {
+	wait, _ := strconv.ParseBool(r.URL.Query().Get("wait"))
+	return wait
+}
+
+func writeSyncError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownJob):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrJobRunning):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrStopping):
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Warn("failed to write HTTP response", "error", err)
+	}
+}
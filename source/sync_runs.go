@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database_sync/database"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	MAX_FAILED_IDS          = 50
+	DEFAULT_SYNC_RUNS_LIMIT = 50
+	MAX_SYNC_RUNS_LIMIT     = 500
+
+	SYNC_RUNS_TTL_INDEX = "finished_at_ttl"
+
+	mongoIndexOptionsConflict = 85
+)
+
+// SyncRunRecord is the history entry of one job execution, kept in the
+// sync_runs collection until the TTL index expires it.
+type SyncRunRecord struct {
+	ID              string     `json:"id" bson:"_id"`
+	CycleID         string     `json:"cycle_id" bson:"cycle_id"`
+	Entity          string     `json:"entity" bson:"entity"`
+	Status          JobOutcome `json:"status" bson:"status"`
+	StartedAt       time.Time  `json:"started_at" bson:"started_at"`
+	FinishedAt      time.Time  `json:"finished_at" bson:"finished_at"`
+	DurationSeconds float64    `json:"duration_seconds" bson:"duration_seconds"`
+	Error           string     `json:"error,omitempty" bson:"error,omitempty"`
+	Stats           SyncStats  `json:"stats" bson:"stats"`
+	FailedIDs       []string   `json:"failed_ids,omitempty" bson:"failed_ids,omitempty"`
+}
+
+func NewSyncRunRecord(run *SyncRun, err error) SyncRunRecord {
+	finishedAt := time.Now()
+	record := SyncRunRecord{
+		ID:              run.ID,
+		CycleID:         run.CycleID,
+		Entity:          run.Entity,
+		Status:          JobSucceeded,
+		StartedAt:       run.StartedAt,
+		FinishedAt:      finishedAt,
+		DurationSeconds: finishedAt.Sub(run.StartedAt).Seconds(),
+		Stats:           run.Stats,
+		FailedIDs:       run.FailedIDs,
+	}
+	if err != nil {
+		record.Status = JobFailed
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// EnsureSyncRunIndexes creates the indexes used by the run history queries
+// and the TTL index that enforces the retention. When the retention changed
+// since the TTL index was created, the index is updated in place.
+func EnsureSyncRunIndexes(ctx context.Context, db *database.MongoDB, retention time.Duration) error {
+	collection := db.Collection(database.COLLECTION_SYNC_RUNS)
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "started_at", Value: -1}}},
+		{Keys: bson.D{{Key: "failed_ids", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create sync_runs indexes: %w", err)
+	}
+
+	return ensureTTLIndex(ctx, db, database.COLLECTION_SYNC_RUNS, "finished_at", SYNC_RUNS_TTL_INDEX, retention)
+}
+
+func ensureTTLIndex(ctx context.Context, db *database.MongoDB, collectionName, field, indexName string, retention time.Duration) error {
+	ttlSeconds := int32(retention.Seconds())
+
+	_, err := db.Collection(collectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(indexName).SetExpireAfterSeconds(ttlSeconds),
+	})
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(mongoIndexOptionsConflict) {
+		err = db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: db.CollectionName(collectionName)},
+			{Key: "index", Value: bson.D{
+				{Key: "name", Value: indexName},
+				{Key: "expireAfterSeconds", Value: ttlSeconds},
+			}},
+		}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s TTL index: %w", collectionName, err)
+	}
+
+	return nil
+}
+
+func SaveSyncRun(ctx context.Context, db *database.MongoDB, record SyncRunRecord) error {
+	if _, err := db.Collection(database.COLLECTION_SYNC_RUNS).InsertOne(ctx, record); err != nil {
+		return fmt.Errorf("failed to save %s sync run: %w", record.Entity, err)
+	}
+	return nil
+}
+
+type SyncRunFilter struct {
+	Entity   string
+	Status   string
+	FailedID string
+	Limit    int64
+}
+
+// FindSyncRuns returns the most recent runs first.
+func FindSyncRuns(ctx context.Context, db *database.MongoDB, filter SyncRunFilter) ([]SyncRunRecord, error) {
+	query := bson.D{}
+	if filter.Entity != "" {
+		query = append(query, bson.E{Key: "entity", Value: filter.Entity})
+	}
+	if filter.Status != "" {
+		query = append(query, bson.E{Key: "status", Value: filter.Status})
+	}
+	if filter.FailedID != "" {
+		query = append(query, bson.E{Key: "failed_ids", Value: filter.FailedID})
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DEFAULT_SYNC_RUNS_LIMIT
+	}
+	limit = min(limit, MAX_SYNC_RUNS_LIMIT)
+
+	cursor, err := db.Collection(database.COLLECTION_SYNC_RUNS).Find(ctx, query,
+		options.Find().SetSort(bson.D{{Key: "started_at", Value: -1}}).SetLimit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync runs: %w", err)
+	}
+
+	records := []SyncRunRecord{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("failed to decode sync runs: %w", err)
+	}
+	return records, nil
+}
+
+// FindSyncRun returns nil when no run has the given ID.
+func FindSyncRun(ctx context.Context, db *database.MongoDB, id string) (*SyncRunRecord, error) {
+	record := &SyncRunRecord{}
+	err := db.Collection(database.COLLECTION_SYNC_RUNS).FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync run %s: %w", id, err)
+	}
+	return record, nil
+}
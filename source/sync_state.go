@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database_sync/database"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type SyncState struct {
+	Entity         string    `json:"entity" bson:"entity"`
+	LastUpdatedAt  time.Time `json:"last_updated_at" bson:"last_updated_at"`
+	LastID         string    `json:"last_id" bson:"last_id"`
+	LastFullSyncAt time.Time `json:"last_full_sync_at" bson:"last_full_sync_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func LoadSyncState(ctx context.Context, db *database.MongoDB, entity string) (*SyncState, error) {
+	state := &SyncState{Entity: entity}
+
+	err := db.Collection(database.COLLECTION_SYNC_STATE).
+		FindOne(ctx, bson.D{{Key: "entity", Value: entity}}).
+		Decode(state)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("failed to load %s sync state: %w", entity, err)
+	}
+
+	return state, nil
+}
+
+// NeedsFullSync reports whether the entity should ignore its watermark and
+// compare the whole table against the whole collection. Incremental cycles
+// never see deleted rows, so deletes only happen on these passes, at most
+// interval apart.
+func (s *SyncState) NeedsFullSync(now time.Time, interval time.Duration) bool {
+	return s.LastUpdatedAt.IsZero() || now.Sub(s.LastFullSyncAt) >= interval
+}
+
+// WhereChanged returns the SQL condition selecting rows modified after the
+// watermark. The id tie-breaker keeps rows sharing the last seen timestamp.
+func (s *SyncState) WhereChanged(updatedAtColumn, idColumn string) (string, []any) {
+	condition := fmt.Sprintf("(%[1]s > ? OR (%[1]s = ? AND %[2]s > ?))", updatedAtColumn, idColumn)
+	return condition, []any{s.LastUpdatedAt, s.LastUpdatedAt, s.LastID}
+}
+
+// Advance moves the watermark forward if the row is newer than it.
+func (s *SyncState) Advance(updatedAt time.Time, id string) {
+	if updatedAt.After(s.LastUpdatedAt) ||
+		(updatedAt.Equal(s.LastUpdatedAt) && compareSyncIDs(id, s.LastID) > 0) {
+		s.LastUpdatedAt = updatedAt
+		s.LastID = id
+	}
+}
+
+// Commit persists the watermark once the run succeeded. A full run also
+// records when it started, so the next reconciliation is scheduled from it.
+func (s *SyncState) Commit(ctx context.Context, db *database.MongoDB, fullSync bool, startedAt time.Time) error {
+	if fullSync {
+		s.LastFullSyncAt = startedAt
+	}
+	s.UpdatedAt = time.Now()
+
+	_, err := db.Collection(database.COLLECTION_SYNC_STATE).UpdateOne(ctx,
+		bson.D{{Key: "entity", Value: s.Entity}},
+		bson.D{{Key: "$set", Value: s}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save %s sync state: %w", s.Entity, err)
+	}
+
+	return nil
+}
+
+// SQL_IN_CHUNK_SIZE bounds the ids of an IN list, far below the 65,535
+// placeholders MySQL allows in a prepared statement.
+const SQL_IN_CHUNK_SIZE = 1000
+
+func sqlPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func compareSyncIDs(a, b string) int {
+	aNum, aErr := strconv.ParseUint(a, 10, 64)
+	bNum, bErr := strconv.ParseUint(b, 10, 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case aNum > bNum:
+			return 1
+		case aNum < bNum:
+			return -1
+		}
+		return 0
+	}
+
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	}
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareSyncIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"10", "9", 1},
+		{"9", "10", -1},
+		{"42", "42", 0},
+		{"b", "a", 1},
+		{"", "1", -1},
+		{"a10", "a9", -1},
+	}
+	for _, test := range tests {
+		if got := compareSyncIDs(test.a, test.b); got != test.want {
+			t.Errorf("compareSyncIDs(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSyncStateAdvance(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := &SyncState{}
+
+	state.Advance(at, "9")
+	state.Advance(at, "10")
+	state.Advance(at, "2")
+	state.Advance(at.Add(-time.Second), "99")
+	if !state.LastUpdatedAt.Equal(at) || state.LastID != "10" {
+		t.Errorf("watermark = %v %q, want %v \"10\"", state.LastUpdatedAt, state.LastID, at)
+	}
+
+	state.Advance(at.Add(time.Second), "1")
+	if state.LastID != "1" {
+		t.Errorf("LastID = %q after a newer row, want \"1\"", state.LastID)
+	}
+}
+
+func TestSyncStateNeedsFullSync(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if !(&SyncState{}).NeedsFullSync(now, time.Hour) {
+		t.Error("a state without watermark does not need a full sync")
+	}
+
+	state := &SyncState{LastUpdatedAt: now, LastFullSyncAt: now.Add(-30 * time.Minute)}
+	if state.NeedsFullSync(now, time.Hour) {
+		t.Error("full sync needed before the interval elapsed")
+	}
+	if !state.NeedsFullSync(now, 30*time.Minute) {
+		t.Error("full sync not needed once the interval elapsed")
+	}
+}
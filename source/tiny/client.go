@@ -0,0 +1,242 @@
+package tiny
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_BASE_URL = "https://api.tiny.com.br/api2"
+
+	ORDER_ENDPOINT   = "pedido.obter.php"
+	INVOICE_ENDPOINT = "nota.fiscal.obter.php"
+
+	// BACKOFF_BASE doubles on every retry, up to BACKOFF_MAX. A minute quota
+	// exceeded is waited out with at least RATE_LIMITED_BACKOFF.
+	BACKOFF_BASE         = 2 * time.Second
+	BACKOFF_MAX          = time.Minute
+	RATE_LIMITED_BACKOFF = 30 * time.Second
+)
+
+// Outcome labels every request attempt for the metrics.
+type Outcome string
+
+const (
+	OutcomeOK          Outcome = "ok"
+	OutcomeHTTPError   Outcome = "http_error"
+	OutcomeRateLimited Outcome = "rate_limited"
+	OutcomeReadError   Outcome = "read_error"
+	OutcomeInvalidJSON Outcome = "invalid_json"
+	OutcomeAPIError    Outcome = "api_error"
+)
+
+type Options struct {
+	Token string
+	// BaseURL defaults to DEFAULT_BASE_URL.
+	BaseURL string
+	// RequestsPerMinute is the quota of the Tiny plan, shared by every call
+	// made through the client.
+	RequestsPerMinute int
+	// Timeout bounds each attempt, retries get a fresh one.
+	Timeout time.Duration
+	// MaxRetries is how many times a transient failure is retried.
+	MaxRetries int
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Observe, when set, is called after every attempt.
+	Observe func(Outcome)
+}
+
+// Client calls the Tiny ERP API v2. It is safe for concurrent use, and every
+// attempt, retries included, waits for the rate limiter.
+type Client struct {
+	options Options
+	limiter *Limiter
+
+	// backoffBase and rateLimitedBackoff are BACKOFF_BASE and
+	// RATE_LIMITED_BACKOFF, shortened by the tests.
+	backoffBase        time.Duration
+	rateLimitedBackoff time.Duration
+}
+
+func NewClient(options Options) *Client {
+	if options.BaseURL == "" {
+		options.BaseURL = DEFAULT_BASE_URL
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	if options.Observe == nil {
+		options.Observe = func(Outcome) {}
+	}
+	return &Client{
+		options:            options,
+		limiter:            NewLimiter(options.RequestsPerMinute, 1),
+		backoffBase:        BACKOFF_BASE,
+		rateLimitedBackoff: RATE_LIMITED_BACKOFF,
+	}
+}
+
+// envelope is what every Tiny response starts with.
+type envelope struct {
+	Retorno struct {
+		StatusProcessamento number `json:"status_processamento"`
+		Status              string `json:"status"`
+		CodigoErro          number `json:"codigo_erro"`
+		Erros               []struct {
+			Erro string `json:"erro"`
+		} `json:"erros"`
+	} `json:"retorno"`
+}
+
+// GetOrder calls pedido.obter for the Tiny order id.
+func (c *Client) GetOrder(ctx context.Context, id string) (*Order, error) {
+	var result struct {
+		Retorno struct {
+			Pedido Order `json:"pedido"`
+		} `json:"retorno"`
+	}
+	if err := c.call(ctx, ORDER_ENDPOINT, url.Values{"id": {id}}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Retorno.Pedido, nil
+}
+
+// GetInvoice calls nota.fiscal.obter for the Tiny invoice id.
+func (c *Client) GetInvoice(ctx context.Context, id string) (*Invoice, error) {
+	var result struct {
+		Retorno struct {
+			NotaFiscal Invoice `json:"nota_fiscal"`
+		} `json:"retorno"`
+	}
+	if err := c.call(ctx, INVOICE_ENDPOINT, url.Values{"id": {id}}, &result); err != nil {
+		return nil, err
+	}
+	return &result.Retorno.NotaFiscal, nil
+}
+
+// call retries the transient failures with exponential backoff and jitter. It
+// returns the last error once the retries are spent.
+func (c *Client) call(ctx context.Context, endpoint string, params url.Values, result any) error {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		err := c.attempt(ctx, endpoint, params, result)
+		if err == nil || !IsRetryable(err) || attempt >= c.options.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt, err))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// attempt decodes a successful response into result.
+func (c *Client) attempt(ctx context.Context, endpoint string, params url.Values, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	// The token goes in the form body: a URL carrying it would show up in
+	// the errors of the HTTP client.
+	form := url.Values{"token": {c.options.Token}, "formato": {"json"}}
+	for key, values := range params {
+		form[key] = values
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.BaseURL+"/"+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build Tiny request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.options.HTTPClient.Do(req)
+	if err != nil {
+		c.options.Observe(OutcomeHTTPError)
+		return &RequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		httpErr := &HTTPError{StatusCode: resp.StatusCode, RetryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+		if errors.Is(httpErr, ErrRateLimited) {
+			c.options.Observe(OutcomeRateLimited)
+		} else {
+			c.options.Observe(OutcomeHTTPError)
+		}
+		return httpErr
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.options.Observe(OutcomeReadError)
+		return &RequestError{Err: fmt.Errorf("failed to read response body: %w", err)}
+	}
+
+	var header envelope
+	if err := json.Unmarshal(body, &header); err != nil {
+		c.options.Observe(OutcomeInvalidJSON)
+		return fmt.Errorf("failed to parse Tiny JSON response: %w", err)
+	}
+
+	retorno := header.Retorno
+	if retorno.Status != "OK" {
+		apiErr := &APIError{ProcessingStatus: int(retorno.StatusProcessamento), Code: ErrorCode(retorno.CodigoErro)}
+		for _, erro := range retorno.Erros {
+			apiErr.Messages = append(apiErr.Messages, erro.Erro)
+		}
+		if errors.Is(apiErr, ErrRateLimited) {
+			c.options.Observe(OutcomeRateLimited)
+		} else {
+			c.options.Observe(OutcomeAPIError)
+		}
+		return apiErr
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		c.options.Observe(OutcomeInvalidJSON)
+		return fmt.Errorf("failed to parse Tiny JSON response: %w", err)
+	}
+
+	c.options.Observe(OutcomeOK)
+	return nil
+}
+
+// backoff is the delay before retry number attempt+1.
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	delay := min(c.backoffBase<<attempt, BACKOFF_MAX)
+	delay += rand.N(delay / 2)
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return min(httpErr.RetryAfter, BACKOFF_MAX)
+	}
+	if errors.Is(err, ErrRateLimited) {
+		delay = max(delay, c.rateLimitedBackoff)
+	}
+	return delay
+}
+
+// retryAfter reads a Retry-After header given in seconds.
+func retryAfter(header string) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
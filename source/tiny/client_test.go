@@ -0,0 +1,245 @@
+package tiny
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const orderResponse = `{"retorno": {"status_processamento": "3", "status": "OK", "pedido": {"id": "123", "numero": "45", "situacao": "Faturado"}}}`
+
+// newTestClient points a client at handler, with delays short enough for the
+// retries to run in the test.
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]Outcome) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	var mu sync.Mutex
+	outcomes := &[]Outcome{}
+	client := NewClient(Options{
+		Token:             "secret-token",
+		BaseURL:           server.URL,
+		RequestsPerMinute: 60_000,
+		Timeout:           time.Second,
+		MaxRetries:        2,
+		Observe: func(outcome Outcome) {
+			mu.Lock()
+			*outcomes = append(*outcomes, outcome)
+			mu.Unlock()
+		},
+	})
+	client.backoffBase = time.Millisecond
+	client.rateLimitedBackoff = time.Millisecond
+	return client, outcomes
+}
+
+func TestGetOrder(t *testing.T) {
+	client, outcomes := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/"+ORDER_ENDPOINT {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if strings.Contains(r.URL.RawQuery, "secret-token") {
+			t.Error("the token was sent in the URL")
+		}
+		if r.FormValue("token") != "secret-token" || r.FormValue("id") != "123" || r.FormValue("formato") != "json" {
+			t.Errorf("form = %v", r.PostForm)
+		}
+		w.Write([]byte(orderResponse))
+	})
+
+	order, err := client.GetOrder(context.Background(), "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.ID != "123" || order.Numero != "45" || order.Situacao != "Faturado" {
+		t.Errorf("order = %+v", order)
+	}
+	if !slices.Equal(*outcomes, []Outcome{OutcomeOK}) {
+		t.Errorf("outcomes = %v", *outcomes)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []func(w http.ResponseWriter)
+		outcomes []Outcome
+	}{
+		{
+			name: "server errors",
+			failures: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			},
+			outcomes: []Outcome{OutcomeHTTPError, OutcomeHTTPError, OutcomeOK},
+		},
+		{
+			name: "HTTP rate limit",
+			failures: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+			},
+			outcomes: []Outcome{OutcomeRateLimited, OutcomeOK},
+		},
+		{
+			name: "API rate limit and maintenance",
+			failures: []func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Write([]byte(`{"retorno": {"status_processamento": 1, "status": "Erro", "codigo_erro": "6"}}`))
+				},
+				func(w http.ResponseWriter) {
+					w.Write([]byte(`{"retorno": {"status_processamento": 1, "status": "Erro", "codigo_erro": 99}}`))
+				},
+			},
+			outcomes: []Outcome{OutcomeRateLimited, OutcomeAPIError, OutcomeOK},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			client, outcomes := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				call := int(calls.Add(1)) - 1
+				if call < len(test.failures) {
+					test.failures[call](w)
+					return
+				}
+				w.Write([]byte(orderResponse))
+			})
+
+			if _, err := client.GetOrder(context.Background(), "123"); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(*outcomes, test.outcomes) {
+				t.Errorf("outcomes = %v, want %v", *outcomes, test.outcomes)
+			}
+		})
+	}
+}
+
+func TestClientGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	_, err := client.GetOrder(context.Background(), "123")
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("error = %v, want the last HTTP 500", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("%d attempts, want 1 + MaxRetries", calls.Load())
+	}
+}
+
+func TestClientDoesNotRetryPermanentErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		check  func(error) bool
+	}{
+		{
+			name:  "not found",
+			body:  `{"retorno": {"status_processamento": "2", "status": "Erro", "codigo_erro": "32", "erros": [{"erro": "Pedido não localizado"}]}}`,
+			check: func(err error) bool { return errors.Is(err, ErrNotFound) },
+		},
+		{
+			name:  "invalid token",
+			body:  `{"retorno": {"status_processamento": "1", "status": "Erro", "codigo_erro": "2"}}`,
+			check: func(err error) bool { return errors.Is(err, ErrUnauthorized) },
+		},
+		{
+			name:  "malformed body",
+			body:  `<html>`,
+			check: func(err error) bool { return err != nil && strings.Contains(err.Error(), "JSON") },
+		},
+		{
+			name:   "client error",
+			status: http.StatusBadRequest,
+			check: func(err error) bool {
+				var httpErr *HTTPError
+				return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusBadRequest
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				if test.status != 0 {
+					w.WriteHeader(test.status)
+				}
+				w.Write([]byte(test.body))
+			})
+
+			_, err := client.GetOrder(context.Background(), "123")
+			if !test.check(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+			if calls.Load() != 1 {
+				t.Errorf("%d attempts, want no retry", calls.Load())
+			}
+		})
+	}
+}
+
+func TestClientStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client.backoffBase = time.Hour
+
+	if _, err := client.GetOrder(ctx, "123"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want the context's", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := NewClient(Options{RequestsPerMinute: 60})
+
+	for attempt := range 8 {
+		delay := client.backoff(attempt, &HTTPError{StatusCode: 503})
+		base := min(BACKOFF_BASE<<attempt, BACKOFF_MAX)
+		if delay < base || delay >= base+base/2 {
+			t.Errorf("attempt %d: delay %v, want within [%v, %v)", attempt, delay, base, base+base/2)
+		}
+	}
+
+	if delay := client.backoff(0, &HTTPError{StatusCode: 429, RetryAfter: 7 * time.Second}); delay != 7*time.Second {
+		t.Errorf("delay = %v, want the Retry-After of 7s", delay)
+	}
+	if delay := client.backoff(0, &HTTPError{StatusCode: 429, RetryAfter: time.Hour}); delay != BACKOFF_MAX {
+		t.Errorf("delay = %v, want Retry-After capped at %v", delay, BACKOFF_MAX)
+	}
+	if delay := client.backoff(0, &APIError{Code: CodeTooManyRequests}); delay < RATE_LIMITED_BACKOFF {
+		t.Errorf("delay = %v, want at least %v when rate limited", delay, RATE_LIMITED_BACKOFF)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":                              0,
+		"12":                            12 * time.Second,
+		"-1":                            0,
+		"Wed, 21 Oct 2015 07:28:00 GMT": 0,
+	}
+	for header, want := range tests {
+		if got := retryAfter(header); got != want {
+			t.Errorf("retryAfter(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tiny
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of status_processamento in every Tiny response.
+const (
+	ProcessingNotProcessed = 1 // solicitação não processada
+	ProcessingWithErrors   = 2 // processada, mas com erros de validação
+	ProcessingOK           = 3 // processada corretamente
+)
+
+// ErrorCode is the codigo_erro of a failed Tiny request.
+type ErrorCode int
+
+const (
+	CodeTokenMissing ErrorCode = 1
+	CodeTokenInvalid ErrorCode = 2
+	// CodeTooManyRequests means the quota of the last minute is used up.
+	CodeTooManyRequests ErrorCode = 6
+	CodeNoRecords       ErrorCode = 20
+	CodeNotFound        ErrorCode = 32
+	CodeMaintenance     ErrorCode = 99
+)
+
+var (
+	ErrUnauthorized = errors.New("tiny rejected the API token")
+	ErrNotFound     = errors.New("tiny record not found")
+	ErrRateLimited  = errors.New("tiny rate limit exceeded")
+)
+
+// APIError is a response whose status is not OK.
+type APIError struct {
+	ProcessingStatus int
+	Code             ErrorCode
+	Messages         []string
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("tiny API error (status_processamento %d, codigo_erro %d)", e.ProcessingStatus, e.Code)
+	if len(e.Messages) > 0 {
+		message += ": " + strings.Join(e.Messages, "; ")
+	}
+	return message
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Code == CodeTokenMissing || e.Code == CodeTokenInvalid
+	case ErrNotFound:
+		return e.Code == CodeNoRecords || e.Code == CodeNotFound
+	case ErrRateLimited:
+		return e.Code == CodeTooManyRequests
+	}
+	return false
+}
+
+func (e *APIError) retryable() bool {
+	return e.Code == CodeTooManyRequests || e.Code == CodeMaintenance
+}
+
+// HTTPError is a response with a status other than 200.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is the delay the server asked for, zero when it did not.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("tiny API answered HTTP %d", e.StatusCode)
+}
+
+func (e *HTTPError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == 429
+}
+
+func (e *HTTPError) retryable() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// RequestError is a request that got no usable response: a network error, a
+// timeout or a truncated body. These are worth retrying.
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return "tiny request failed: " + e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) retryable() bool {
+	return true
+}
+
+// IsRetryable reports whether err is transient, so the same request may
+// succeed later.
+func IsRetryable(err error) bool {
+	var retryable interface{ retryable() bool }
+	return errors.As(err, &retryable) && retryable.retryable()
+}
+
+// number decodes the numeric fields Tiny sends either as strings or as
+// numbers, such as status_processamento.
+type number int
+
+func (n *number) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	if text == "" || text == "null" {
+		*n = 0
+		return nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", data)
+	}
+	*n = number(value)
+	return nil
+}
@@ -0,0 +1,59 @@
+package tiny
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterBurst(t *testing.T) {
+	limiter := NewLimiter(600, 3)
+
+	start := time.Now()
+	for range 3 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("the burst took %v, want no wait", elapsed)
+	}
+
+	// Past the burst, requests are spaced by the 100ms interval.
+	start = time.Now()
+	for range 2 {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("two requests past the burst took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	limiter := NewLimiter(600, 1)
+	limiter.Wait(context.Background())
+
+	time.Sleep(120 * time.Millisecond)
+	if delay := limiter.reserve(); delay > 0 {
+		t.Errorf("delay = %v after an interval, want a refilled token", delay)
+	}
+}
+
+func TestLimiterCanceledWaitReturnsToken(t *testing.T) {
+	limiter := NewLimiter(60, 1)
+	limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err == nil {
+		t.Fatal("the wait outlived its context")
+	}
+
+	// The canceled reservation was handed back, so the next caller waits one
+	// interval, not two.
+	if delay := limiter.reserve(); delay > time.Second {
+		t.Errorf("delay = %v, want at most one interval", delay)
+	}
+}
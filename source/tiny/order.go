@@ -0,0 +1,87 @@
+package tiny
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Order is the part of the pedido.obter response the synchronizer uses. The
+// customer data is left out on purpose, it already lives in the platform.
+type Order struct {
+	ID            string  `json:"id"`
+	Numero        string  `json:"numero"`
+	Situacao      string  `json:"situacao"`
+	TotalProdutos Decimal `json:"total_produtos"`
+	TotalPedido   Decimal `json:"total_pedido"`
+	ValorFrete    Decimal `json:"valor_frete"`
+	ValorDesconto Decimal `json:"valor_desconto"`
+	// IDNotaFiscal is the id of the invoice, "" or "0" until the order is
+	// invoiced. Its number comes from GetInvoice.
+	IDNotaFiscal string `json:"id_nota_fiscal"`
+	Itens        []struct {
+		Item OrderItem `json:"item"`
+	} `json:"itens"`
+
+	FormaFrete         string `json:"forma_frete"`
+	CodigoRastreamento string `json:"codigo_rastreamento"`
+	URLRastreamento    string `json:"url_rastreamento"`
+}
+
+type OrderItem struct {
+	IDProduto     string  `json:"id_produto"`
+	Codigo        string  `json:"codigo"`
+	Descricao     string  `json:"descricao"`
+	Unidade       string  `json:"unidade"`
+	Quantidade    Decimal `json:"quantidade"`
+	ValorUnitario Decimal `json:"valor_unitario"`
+}
+
+// Items returns the item lines without the wrapper object Tiny puts around
+// each of them.
+func (o *Order) Items() []OrderItem {
+	items := make([]OrderItem, 0, len(o.Itens))
+	for _, entry := range o.Itens {
+		items = append(items, entry.Item)
+	}
+	return items
+}
+
+// Invoiced reports whether the order has an invoice to look up.
+func (o *Order) Invoiced() bool {
+	return o.IDNotaFiscal != "" && o.IDNotaFiscal != "0"
+}
+
+// Invoice is the part of the nota.fiscal.obter response the synchronizer
+// uses.
+type Invoice struct {
+	ID          string `json:"id"`
+	Numero      string `json:"numero"`
+	Serie       string `json:"serie"`
+	ChaveAcesso string `json:"chave_acesso"`
+	DataEmissao string `json:"data_emissao"`
+	Situacao    string `json:"situacao"`
+}
+
+// Decimal decodes the amounts Tiny sends as strings, such as "150.00", or as
+// numbers. An empty amount is zero.
+type Decimal float64
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		text = string(data)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" || text == "null" {
+		*d = 0
+		return nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("invalid decimal %s", data)
+	}
+	*d = Decimal(value)
+	return nil
+}
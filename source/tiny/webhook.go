@@ -0,0 +1,101 @@
+package tiny
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Webhook is a notification Tiny posts when an order changes situação or
+// gets a tracking code. Both kinds carry the order in dados, the tracking
+// ones under idPedido instead of id.
+type Webhook struct {
+	Versao string      `json:"versao"`
+	CNPJ   string      `json:"cnpj"`
+	Tipo   string      `json:"tipo"`
+	Dados  WebhookData `json:"dados"`
+}
+
+type WebhookData struct {
+	ID       text `json:"id"`
+	IDPedido text `json:"idPedido"`
+
+	CodigoSituacao    string `json:"codigoSituacao"`
+	DescricaoSituacao string `json:"descricaoSituacao"`
+
+	CodigoRastreio string `json:"codigoRastreio"`
+	URLRastreio    string `json:"urlRastreio"`
+	FormaFrete     string `json:"formaFrete"`
+	FormaEnvio     string `json:"formaEnvio"`
+}
+
+// ParseWebhook decodes and validates a notification. It must name an order
+// and carry either a situação or a tracking code.
+func ParseWebhook(body []byte) (*Webhook, error) {
+	var webhook Webhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, fmt.Errorf("failed to parse Tiny webhook: %w", err)
+	}
+	if webhook.OrderID() == "" {
+		return nil, errors.New("tiny webhook without an order id")
+	}
+	if webhook.Situacao() == "" && webhook.Dados.CodigoRastreio == "" {
+		return nil, errors.New("tiny webhook without a situação or a tracking code")
+	}
+	return &webhook, nil
+}
+
+func (w *Webhook) OrderID() string {
+	if w.Dados.ID != "" {
+		return string(w.Dados.ID)
+	}
+	return string(w.Dados.IDPedido)
+}
+
+// Situacao is the situação as pedido.obter names it, such as "Entregue".
+func (w *Webhook) Situacao() string {
+	return w.Dados.DescricaoSituacao
+}
+
+// Service is the shipping service, as forma_frete in pedido.obter.
+func (w *Webhook) Service() string {
+	if w.Dados.FormaFrete != "" {
+		return w.Dados.FormaFrete
+	}
+	return w.Dados.FormaEnvio
+}
+
+// Key identifies what the notification says, so a notification Tiny sends
+// again, whatever else changed in its body, has the same key. Tiny gives no
+// id or time of its own to the event, so a genuine repeat of the same
+// change has the same key too, and only the time it arrives tells them
+// apart.
+func (w *Webhook) Key() string {
+	parts := []string{
+		w.Tipo, w.OrderID(),
+		w.Dados.CodigoSituacao, w.Dados.DescricaoSituacao,
+		w.Dados.CodigoRastreio, w.Dados.URLRastreio, w.Service(),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// text decodes the ids Tiny sends either as strings or as numbers.
+type text string
+
+func (t *text) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*t = text(value)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("invalid id %s", data)
+	}
+	*t = text(number.String())
+	return nil
+}
@@ -4,15 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"database_sync/database"
-	"database_sync/utils"
 	"fmt"
-	"os"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 const (
@@ -28,13 +24,15 @@ const (
 )
 
 type MongoDBUsers struct {
-	ID        bson.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	OldID     uint64        `json:"old_id" bson:"old_id"`
-	Name      string        `json:"name" bson:"name"`
-	Email     string        `json:"email" bson:"email"`
-	Role      []string      `json:"role" bson:"role"`
-	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
+	ID            bson.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	OldID         uint64        `json:"old_id" bson:"old_id"`
+	Name          string        `json:"name" bson:"name"`
+	Email         string        `json:"email" bson:"email"`
+	Role          []string      `json:"role" bson:"role"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at" bson:"updated_at"`
+	DeletedAt     *time.Time    `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	DeletedBySync bool          `json:"deleted_by_sync,omitempty" bson:"deleted_by_sync,omitempty"`
 }
 
 type MySQLUsers struct {
@@ -75,39 +73,19 @@ func GetRoleString(roleID uint) string {
 	}
 }
 
-func SyncUsers() error {
-	mysqlURI := os.Getenv("MYSQL_URI")
+func SyncUsers(ctx context.Context, deps *SyncDeps, run *SyncRun) error {
+	mysqlDB := deps.Connections.MySQL()
 
-	mysqlDB, err := sql.Open("mysql", mysqlURI)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MySQL: %w", err)
-	}
-	defer mysqlDB.Close()
-
-	mysqlDB.SetConnMaxLifetime(database.MYSQL_CONN_MAX_LIFETIME)
-	mysqlDB.SetMaxOpenConns(database.MYSQL_MAX_OPEN_CONNS)
-	mysqlDB.SetMaxIdleConns(database.MYSQL_MAX_IDLE_CONNS)
-
-	if err := mysqlDB.Ping(); err != nil {
-		return fmt.Errorf("failed to ping MySQL: %w", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), database.MONGODB_TIMEOUT)
+	ctx, cancel := context.WithTimeout(ctx, deps.Config.Sync.JobTimeout)
 	defer cancel()
 
-	mongoURI := os.Getenv(utils.MONGODB_URI)
-	opts := options.Client().ApplyURI(mongoURI)
-	mongoClient, err := mongo.Connect(opts)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %w", err)
-	}
-	defer mongoClient.Disconnect(ctx)
+	mongoDB := deps.Connections.MongoDB()
 
-	usersCollection := mongoClient.Database(database.GetDB()).Collection(database.COLLECTION_USERS)
+	usersCollection := mongoDB.Collection(database.COLLECTION_USERS)
 
 	allUsersMap := make(map[uint64]*MySQLUsers)
 
-	dataRows, err := mysqlDB.Query("SELECT id, name, email, created_at, updated_at FROM users WHERE id IS NOT NULL")
+	dataRows, err := mysqlDB.QueryContext(ctx, "SELECT id, name, email, created_at, updated_at FROM users WHERE id IS NOT NULL")
 	if err != nil {
 		return fmt.Errorf("failed to query MySQL users data: %w", err)
 	}
@@ -153,6 +131,7 @@ func SyncUsers() error {
 	if err = dataRows.Err(); err != nil {
 		return fmt.Errorf("error iterating MySQL data rows: %w", err)
 	}
+	run.Stats.MySQLRows = len(allUsersMap)
 
 	if len(allUsersMap) == 0 {
 		return nil
@@ -164,7 +143,7 @@ func SyncUsers() error {
 	}
 
 	roleUserMap := make(map[uint64][]uint)
-	roleUserRows, err := mysqlDB.Query("SELECT user_id, role_id FROM role_user WHERE user_id IS NOT NULL")
+	roleUserRows, err := mysqlDB.QueryContext(ctx, "SELECT user_id, role_id FROM role_user WHERE user_id IS NOT NULL")
 	if err != nil {
 		return fmt.Errorf("failed to query MySQL role_user table: %w", err)
 	}
@@ -200,8 +179,11 @@ func SyncUsers() error {
 		if err := cursor.Decode(&user); err != nil {
 			return fmt.Errorf("failed to decode MongoDB user: %w", err)
 		}
+		run.Stats.MongoDocs++
 		if user.OldID > 0 {
-			mongoIDs[user.OldID] = true
+			if user.DeletedAt == nil {
+				mongoIDs[user.OldID] = true
+			}
 			mongoUsersData[user.OldID] = user
 		}
 	}
@@ -217,13 +199,11 @@ func SyncUsers() error {
 		}
 	}
 
-	if len(idsToDelete) > 0 {
-		deleteFilter := bson.D{{Key: "old_id", Value: bson.D{{Key: "$in", Value: idsToDelete}}}}
-		_, err := usersCollection.DeleteMany(ctx, deleteFilter)
-		if err != nil {
-			return fmt.Errorf("failed to delete non-existing users from MongoDB: %w", err)
-		}
+	deleted, err := reconcileDeletes(ctx, run, usersCollection, "old_id", idsToDelete, len(mongoIDs))
+	if err != nil {
+		return err
 	}
+	run.Stats.Deleted = deleted
 
 	idsToUpsert := []uint64{}
 
@@ -266,21 +246,19 @@ func SyncUsers() error {
 		if mysqlUser.Name != mongoUser.Name ||
 			mysqlUser.Email != mongoUser.Email ||
 			!mysqlUser.UpdatedAt.Equal(mongoUser.UpdatedAt) ||
-			rolesChanged {
+			rolesChanged ||
+			mongoUser.DeletedBySync {
 			idsToUpsert = append(idsToUpsert, id)
 		}
 	}
 
+	run.Stats.Skipped = len(allUsersMap) - len(idsToUpsert)
+
 	if len(idsToUpsert) == 0 {
 		return nil
 	}
 
-	batchSize := 50
-	if len(idsToUpsert) > 1000 {
-		batchSize = 200
-	} else if len(idsToUpsert) > 5000 {
-		batchSize = 500
-	}
+	batchSize := deps.Config.Sync.BatchSizeFor(len(idsToUpsert))
 
 	bulkOperations := []mongo.WriteModel{}
 
@@ -308,7 +286,8 @@ func SyncUsers() error {
 		}
 
 		filter := bson.D{{Key: "old_id", Value: mongoUser.OldID}}
-		update := bson.D{{Key: "$set", Value: mongoUser}}
+		update := upsertUpdate(mongoUser, mongoUsersData[id].DeletedBySync)
+		run.Changes.Upsert(id, snapshotOf(mongoUsersData, id), mongoUser)
 
 		upsertModel := mongo.NewUpdateOneModel().
 			SetFilter(filter).
@@ -318,7 +297,7 @@ func SyncUsers() error {
 		bulkOperations = append(bulkOperations, upsertModel)
 
 		if len(bulkOperations) >= batchSize {
-			if _, err := usersCollection.BulkWrite(ctx, bulkOperations); err != nil {
+			if err := run.BulkWrite(ctx, usersCollection, bulkOperations); err != nil {
 				return fmt.Errorf("failed to execute bulk write: %w", err)
 			}
 			bulkOperations = []mongo.WriteModel{}
@@ -326,7 +305,7 @@ func SyncUsers() error {
 	}
 
 	if len(bulkOperations) > 0 {
-		if _, err := usersCollection.BulkWrite(ctx, bulkOperations); err != nil {
+		if err := run.BulkWrite(ctx, usersCollection, bulkOperations); err != nil {
 			return fmt.Errorf("failed to execute final bulk write: %w", err)
 		}
 	}
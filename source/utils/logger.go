@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"database_sync/config"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// SetupLogger installs the process-wide slog logger described by the log
+// settings (json by default, so container logs can be queried), writing to w.
+// Every message and string or error attribute goes through redact first.
+func SetupLogger(w io.Writer, settings config.LogConfig, redact func(string) string) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToLower(settings.Level))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			return redactAttr(attr, redact)
+		},
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if settings.Format == config.LOG_FORMAT_TEXT {
+		handler = slog.NewTextHandler(w, options)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// redactAttr is called on resolved values, groups already walked into by the
+// handler, so only strings and errors need a look.
+func redactAttr(attr slog.Attr, redact func(string) string) slog.Attr {
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(redact(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(redact(err.Error()))
+		}
+	}
+	return attr
+}
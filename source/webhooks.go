@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database_sync/database"
+	"database_sync/tiny"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// MAX_WEBHOOK_SIZE bounds a notification body, Tiny's are a few hundred
+	// bytes.
+	MAX_WEBHOOK_SIZE = 64 << 10
+
+	WEBHOOK_SECRET_PARAM  = "secret"
+	WEBHOOK_SECRET_HEADER = "X-Webhook-Secret"
+
+	WEBHOOK_EVENTS_TTL_INDEX = "received_at_ttl"
+
+	// WEBHOOK_DEDUP_WINDOW is how long a notification counts as a repeat of
+	// an earlier one with the same content. Tiny resends within minutes when
+	// it got no answer, while a genuine change back, such as a situação going
+	// A, B and then A again, comes much later.
+	WEBHOOK_DEDUP_WINDOW = 10 * time.Minute
+
+	TINY_SITUACAO_ENTREGUE = "Entregue"
+)
+
+// Outcomes of a Tiny webhook, for the metrics and the response.
+const (
+	WebhookApplied      = "applied"
+	WebhookDuplicate    = "duplicate"
+	WebhookUnknownOrder = "unknown_order"
+	WebhookRejected     = "rejected"
+	WebhookFailed       = "failed"
+)
+
+// WebhookEvent records a notification that was received, keyed by what it
+// says, so the ones Tiny sends again within WEBHOOK_DEDUP_WINDOW are
+// recognized.
+type WebhookEvent struct {
+	Key        string    `bson:"_id"`
+	Source     string    `bson:"source"`
+	Type       string    `bson:"type,omitempty"`
+	OrderID    string    `bson:"order_id"`
+	Payload    string    `bson:"payload"`
+	ReceivedAt time.Time `bson:"received_at"`
+}
+
+// EnsureWebhookEventIndexes expires the received notifications with the same
+// retention as the run history. Only the last WEBHOOK_DEDUP_WINDOW of them
+// deduplicate, the older ones are kept as history.
+func EnsureWebhookEventIndexes(ctx context.Context, db *database.MongoDB, retention time.Duration) error {
+	return ensureTTLIndex(ctx, db, database.COLLECTION_WEBHOOK_EVENTS, "received_at", WEBHOOK_EVENTS_TTL_INDEX, retention)
+}
+
+// WebhookServer receives the notifications of Tiny. It listens on its own
+// address, so the status server and its POST /sync routes, which have no
+// authentication, need not be exposed to the internet along with it.
+type WebhookServer struct {
+	deps *SyncDeps
+	// collection opens the collections the webhook writes to.
+	collection func(name string) webhookCollection
+}
+
+// webhookCollection is the part of a MongoDB collection the webhook uses.
+type webhookCollection interface {
+	ReplaceOne(ctx context.Context, filter, replacement any, opts ...options.Lister[options.ReplaceOptions]) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter any, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error)
+}
+
+func NewWebhookServer(deps *SyncDeps) *http.Server {
+	s := &WebhookServer{
+		deps: deps,
+		collection: func(name string) webhookCollection {
+			return deps.Connections.MongoDB().Collection(name)
+		},
+	}
+
+	return &http.Server{
+		Addr:              deps.Config.Tiny.WebhookAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: HTTP_READ_HEADER_TIMEOUT,
+	}
+}
+
+func (s *WebhookServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhooks/tiny", s.handleTinyWebhook)
+	return mux
+}
+
+// handleTinyWebhook applies a Tiny order or tracking notification to the
+// order with the same tiny.id. The tracking job keeps polling Tiny, so a
+// notification that is lost or arrives before its order was synced is only
+// applied later.
+//
+// Tiny does not sign its notifications, so the URL configured there carries
+// the secret: /webhooks/tiny?secret=<TINY_WEBHOOK_SECRET>.
+func (s *WebhookServer) handleTinyWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := r.URL.Query().Get(WEBHOOK_SECRET_PARAM)
+	if secret == "" {
+		secret = r.Header.Get(WEBHOOK_SECRET_HEADER)
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.deps.Config.Tiny.WebhookSecret)) != 1 {
+		metricTinyWebhooks.WithLabelValues(WebhookRejected).Inc()
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid webhook secret"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_WEBHOOK_SIZE))
+	if err != nil {
+		metricTinyWebhooks.WithLabelValues(WebhookRejected).Inc()
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "failed to read the notification"})
+		return
+	}
+
+	webhook, err := tiny.ParseWebhook(body)
+	if err != nil {
+		metricTinyWebhooks.WithLabelValues(WebhookRejected).Inc()
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger := slog.With("webhook", "tiny", "type", webhook.Tipo, "tiny_id", webhook.OrderID())
+
+	// The update is not tied to the request: once the notification is
+	// recorded as received, it must also be applied.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), s.deps.Config.Sync.WriteTimeout)
+	defer cancel()
+
+	outcome, err := s.applyTinyWebhook(ctx, webhook, body)
+	metricTinyWebhooks.WithLabelValues(outcome).Inc()
+	if err != nil {
+		logger.Warn("failed to apply Tiny webhook", "error", s.deps.Config.RedactError(err))
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": s.deps.Config.Redact(err.Error())})
+		return
+	}
+
+	logger.Info("Tiny webhook received", "outcome", outcome)
+	writeJSON(w, http.StatusOK, map[string]string{"status": outcome})
+}
+
+// applyTinyWebhook records the notification, then updates its order. When the
+// update fails the record is removed again, so the retry of Tiny goes
+// through.
+func (s *WebhookServer) applyTinyWebhook(ctx context.Context, webhook *tiny.Webhook, body []byte) (string, error) {
+	events := s.collection(database.COLLECTION_WEBHOOK_EVENTS)
+
+	now := time.Now()
+	event := WebhookEvent{
+		Key:        webhook.Key(),
+		Source:     "tiny",
+		Type:       webhook.Tipo,
+		OrderID:    webhook.OrderID(),
+		Payload:    string(body),
+		ReceivedAt: now,
+	}
+	// An earlier record of the same key is replaced once it is older than
+	// the window. A recent one does not match, so the upsert collides with
+	// its _id and the notification is a duplicate.
+	filter := bson.D{
+		{Key: "_id", Value: event.Key},
+		{Key: "received_at", Value: bson.D{{Key: "$lte", Value: now.Add(-WEBHOOK_DEDUP_WINDOW)}}},
+	}
+	if _, err := events.ReplaceOne(ctx, filter, event, options.Replace().SetUpsert(true)); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return WebhookDuplicate, nil
+		}
+		return WebhookFailed, fmt.Errorf("failed to record webhook: %w", err)
+	}
+
+	outcome, err := s.updateOrderFromWebhook(ctx, webhook)
+	if err != nil {
+		if _, deleteErr := events.DeleteOne(ctx, bson.D{{Key: "_id", Value: event.Key}}); deleteErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to forget webhook: %w", deleteErr))
+		}
+		return WebhookFailed, err
+	}
+	return outcome, nil
+}
+
+func (s *WebhookServer) updateOrderFromWebhook(ctx context.Context, webhook *tiny.Webhook) (string, error) {
+	orders := s.collection(database.COLLECTION_ORDERS)
+	filter := bson.D{
+		{Key: "tiny.id", Value: webhook.OrderID()},
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+
+	// Dotted keys, as in the tracking job, leave the rest of tracking and
+	// tiny untouched.
+	// Fresh data from Tiny also restarts the checks of the tracking job, and
+	// a tracking code those of the carriers job, stopped or not.
+	set := bson.D{{Key: "tracking.attempts", Value: 0}}
+	unset := bson.D{
+		{Key: "tracking.next_check_at", Value: ""},
+		{Key: "tracking.stopped_at", Value: ""},
+	}
+	if webhook.Dados.CodigoRastreio != "" {
+		set = append(set, bson.E{Key: "tracking.code", Value: webhook.Dados.CodigoRastreio})
+		if webhook.Dados.URLRastreio != "" {
+			set = append(set, bson.E{Key: "tracking.url", Value: webhook.Dados.URLRastreio})
+		}
+		if service := webhook.Service(); service != "" {
+			set = append(set, bson.E{Key: "tracking.service", Value: service})
+		}
+		unset = append(unset, carrierCheckFields...)
+	}
+	delivered := webhook.Situacao() == TINY_SITUACAO_ENTREGUE
+	if webhook.Situacao() != "" {
+		set = append(set, bson.E{Key: "tiny.situacao", Value: webhook.Situacao()})
+	}
+
+	update := bson.D{{Key: "$set", Value: set}, {Key: "$unset", Value: unset}}
+	if delivered {
+		// $min keeps the time a carrier reported, which is earlier.
+		update = append(update, bson.E{Key: "$min", Value: bson.D{{Key: "tracking.delivered_at", Value: time.Now()}}})
+	}
+
+	result, err := orders.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return "", fmt.Errorf("failed to update order: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return WebhookUnknownOrder, nil
+	}
+
+	if delivered && s.deps.Config.Carriers.AutoDeliver {
+		statusFilter := append(filter, bson.E{Key: "status", Value: bson.D{{Key: "$nin", Value: finishedOrderStatuses}}})
+		statusUpdate := bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: StatusEntregue}}}}
+		if _, err := orders.UpdateOne(ctx, statusFilter, statusUpdate); err != nil {
+			return "", fmt.Errorf("failed to update order status: %w", err)
+		}
+	}
+	return WebhookApplied, nil
+}
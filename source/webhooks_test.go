@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database_sync/config"
+	"database_sync/database"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const testWebhookSecret = "0123456789abcdef"
+
+// fakeWebhookCollection keeps webhook_events in memory and records the
+// updates sent to orders. It understands the filters of the webhook only.
+type fakeWebhookCollection struct {
+	mu sync.Mutex
+	// events are the received_at of the recorded notifications, by key.
+	events map[string]time.Time
+	// orders are the tiny.id of the existing orders.
+	orders  map[string]bool
+	updates []bson.D
+	err     error
+}
+
+func lookup(document bson.D, key string) any {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value
+		}
+	}
+	return nil
+}
+
+func (f *fakeWebhookCollection) ReplaceOne(_ context.Context, filter, replacement any, _ ...options.Lister[options.ReplaceOptions]) (*mongo.UpdateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := lookup(filter.(bson.D), "_id").(string)
+	before := lookup(lookup(filter.(bson.D), "received_at").(bson.D), "$lte").(time.Time)
+	if receivedAt, exists := f.events[key]; exists && receivedAt.After(before) {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	}
+	f.events[key] = replacement.(WebhookEvent).ReceivedAt
+	return &mongo.UpdateResult{UpsertedCount: 1}, nil
+}
+
+func (f *fakeWebhookCollection) UpdateOne(_ context.Context, filter, update any, _ ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !f.orders[lookup(filter.(bson.D), "tiny.id").(string)] {
+		return &mongo.UpdateResult{}, nil
+	}
+	f.updates = append(f.updates, update.(bson.D))
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (f *fakeWebhookCollection) DeleteOne(_ context.Context, filter any, _ ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.events, lookup(filter.(bson.D), "_id").(string))
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func newTestWebhookServer(t *testing.T, autoDeliver bool) (*httptest.Server, *fakeWebhookCollection) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Tiny.WebhookSecret = testWebhookSecret
+	cfg.Carriers.AutoDeliver = autoDeliver
+
+	store := &fakeWebhookCollection{events: map[string]time.Time{}, orders: map[string]bool{"123": true}}
+	s := &WebhookServer{
+		deps: &SyncDeps{Config: cfg},
+		collection: func(name string) webhookCollection {
+			if name != database.COLLECTION_WEBHOOK_EVENTS && name != database.COLLECTION_ORDERS {
+				t.Errorf("unexpected collection %s", name)
+			}
+			return store
+		},
+	}
+
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+// postWebhook sends body and returns the status code and the status or error
+// of the response.
+func postWebhook(t *testing.T, server *httptest.Server, secret, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/webhooks/tiny?secret="+secret, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response["error"] != "" {
+		return resp.StatusCode, response["error"]
+	}
+	return resp.StatusCode, response["status"]
+}
+
+const (
+	trackingWebhook  = `{"versao": "1.0.0", "cnpj": "00000000000000", "tipo": "rastreio", "dados": {"idPedido": 123, "codigoRastreio": "QB123456789BR", "urlRastreio": "https://rastreamento.correios.com.br", "formaFrete": "Correios SEDEX"}}`
+	deliveredWebhook = `{"versao": "1.0.0", "cnpj": "00000000000000", "tipo": "situacao_pedido", "dados": {"id": "123", "codigoSituacao": "entregue", "descricaoSituacao": "Entregue"}}`
+)
+
+func TestTinyWebhookRejectsBadSecret(t *testing.T) {
+	server, store := newTestWebhookServer(t, false)
+
+	for _, secret := range []string{"", "wrong", testWebhookSecret + "x"} {
+		if status, _ := postWebhook(t, server, secret, trackingWebhook); status != http.StatusUnauthorized {
+			t.Errorf("secret %q: HTTP %d, want 401", secret, status)
+		}
+	}
+	if len(store.events) > 0 || len(store.updates) > 0 {
+		t.Error("a rejected notification was written")
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/webhooks/tiny", strings.NewReader(trackingWebhook))
+	req.Header.Set(WEBHOOK_SECRET_HEADER, testWebhookSecret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("secret in the header: HTTP %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTinyWebhookRejectsInvalidBody(t *testing.T) {
+	server, _ := newTestWebhookServer(t, false)
+
+	for _, body := range []string{`{"dados": `, `{"tipo": "rastreio", "dados": {"codigoRastreio": "QB1"}}`, `{"dados": {"id": "123"}}`} {
+		if status, _ := postWebhook(t, server, testWebhookSecret, body); status != http.StatusBadRequest {
+			t.Errorf("body %s: HTTP %d, want 400", body, status)
+		}
+	}
+}
+
+func TestTinyWebhookAppliesTracking(t *testing.T) {
+	server, store := newTestWebhookServer(t, false)
+
+	status, outcome := postWebhook(t, server, testWebhookSecret, trackingWebhook)
+	if status != http.StatusOK || outcome != WebhookApplied {
+		t.Fatalf("HTTP %d %q, want 200 applied", status, outcome)
+	}
+	if len(store.events) != 1 || len(store.updates) != 1 {
+		t.Fatalf("%d events and %d updates, want one of each", len(store.events), len(store.updates))
+	}
+
+	set := lookup(store.updates[0], "$set").(bson.D)
+	want := map[string]string{
+		"tracking.code":    "QB123456789BR",
+		"tracking.url":     "https://rastreamento.correios.com.br",
+		"tracking.service": "Correios SEDEX",
+	}
+	for key, value := range want {
+		if got := lookup(set, key); got != value {
+			t.Errorf("%s = %v, want %q", key, got, value)
+		}
+	}
+	if lookup(store.updates[0], "$min") != nil || lookup(set, "tiny.situacao") != nil {
+		t.Errorf("update = %v, want the tracking fields only", store.updates[0])
+	}
+
+	// An order the tracking jobs gave up on is checked again.
+	unset := lookup(store.updates[0], "$unset").(bson.D)
+	for _, key := range []string{"tracking.stopped_at", "tracking.next_check_at", "tracking.carrier_stopped_at", "tracking.carrier_next_check_at", "tracking.carrier_attempts"} {
+		if lookup(unset, key) == nil {
+			t.Errorf("$unset = %v, want %s cleared", unset, key)
+		}
+	}
+	if lookup(set, "tracking.attempts") != 0 {
+		t.Errorf("$set = %v, want tracking.attempts reset", set)
+	}
+}
+
+func TestTinyWebhookDelivered(t *testing.T) {
+	server, store := newTestWebhookServer(t, true)
+
+	if status, outcome := postWebhook(t, server, testWebhookSecret, deliveredWebhook); status != http.StatusOK || outcome != WebhookApplied {
+		t.Fatalf("HTTP %d %q, want 200 applied", status, outcome)
+	}
+	if len(store.updates) != 2 {
+		t.Fatalf("updates = %v, want the order and then its status", store.updates)
+	}
+	if lookup(lookup(store.updates[0], "$set").(bson.D), "tiny.situacao") != "Entregue" {
+		t.Errorf("update = %v, want tiny.situacao set", store.updates[0])
+	}
+	if lookup(store.updates[0], "$min") == nil {
+		t.Errorf("update = %v, want tracking.delivered_at set with $min", store.updates[0])
+	}
+	unset := lookup(store.updates[0], "$unset").(bson.D)
+	if lookup(unset, "tracking.stopped_at") == nil || lookup(unset, "tracking.carrier_stopped_at") != nil {
+		t.Errorf("$unset = %v, want the Tiny checks restarted and the carrier ones kept", unset)
+	}
+	if lookup(lookup(store.updates[1], "$set").(bson.D), "status") != StatusEntregue {
+		t.Errorf("update = %v, want the status moved to Entregue", store.updates[1])
+	}
+}
+
+func TestTinyWebhookDuplicate(t *testing.T) {
+	server, store := newTestWebhookServer(t, false)
+
+	postWebhook(t, server, testWebhookSecret, trackingWebhook)
+	// Tiny sending it again, with its own fields changed, is a duplicate.
+	again := strings.Replace(trackingWebhook, `"versao": "1.0.0"`, `"versao": "1.0.1"`, 1)
+	status, outcome := postWebhook(t, server, testWebhookSecret, again)
+	if status != http.StatusOK || outcome != WebhookDuplicate {
+		t.Fatalf("HTTP %d %q, want 200 duplicate", status, outcome)
+	}
+	if len(store.updates) != 1 {
+		t.Errorf("the order was updated %d times, want once", len(store.updates))
+	}
+
+	// Past the window, the same content is a new change and is applied.
+	for key := range store.events {
+		store.events[key] = time.Now().Add(-WEBHOOK_DEDUP_WINDOW - time.Minute)
+	}
+	if _, outcome := postWebhook(t, server, testWebhookSecret, trackingWebhook); outcome != WebhookApplied {
+		t.Errorf("outcome %q after the window, want applied", outcome)
+	}
+	if len(store.updates) != 2 {
+		t.Errorf("the order was updated %d times, want twice", len(store.updates))
+	}
+}
+
+func TestTinyWebhookUnknownOrder(t *testing.T) {
+	server, store := newTestWebhookServer(t, false)
+
+	body := strings.Replace(trackingWebhook, `"idPedido": 123`, `"idPedido": 456`, 1)
+	status, outcome := postWebhook(t, server, testWebhookSecret, body)
+	if status != http.StatusOK || outcome != WebhookUnknownOrder {
+		t.Fatalf("HTTP %d %q, want 200 unknown_order", status, outcome)
+	}
+	if len(store.updates) > 0 {
+		t.Error("an order was updated")
+	}
+}
+
+func TestTinyWebhookFailureIsForgotten(t *testing.T) {
+	server, store := newTestWebhookServer(t, false)
+	store.err = errors.New("not primary")
+
+	if status, _ := postWebhook(t, server, testWebhookSecret, trackingWebhook); status != http.StatusInternalServerError {
+		t.Fatalf("HTTP %d, want 500", status)
+	}
+	if len(store.events) > 0 {
+		t.Fatal("a notification that failed stays recorded, its retry would be a duplicate")
+	}
+
+	store.err = nil
+	if _, outcome := postWebhook(t, server, testWebhookSecret, trackingWebhook); outcome != WebhookApplied {
+		t.Errorf("retry outcome %q, want applied", outcome)
+	}
+}